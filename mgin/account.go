@@ -0,0 +1,305 @@
+package mgin
+
+import (
+	"github.com/kacifer/mc"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/kacifer/mc/mlog"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const DefaultResetTokenLease = time.Hour
+
+// AccountConfig enables the account routes of Custom, CustomAuthConfig.UserStore must be an AccountStore.
+type AccountConfig struct {
+	// Registration enables the register route.
+	Registration bool
+
+	PasswordPolicy PasswordPolicy
+
+	// Notifier enables the password reset routes, it delivers the reset links.
+	Notifier Notifier
+
+	// ResetURL is the link sent by Notifier, with {token} replaced by the token,
+	// e.g. https://app.example.com/reset?token={token}, the link is the token itself if empty.
+	ResetURL string
+
+	// ResetTokenLease defaults to DefaultResetTokenLease.
+	ResetTokenLease time.Duration
+
+	// BcryptCost defaults to bcrypt.DefaultCost.
+	BcryptCost int
+
+	NowFunc func() time.Time
+}
+
+func (a *AccountConfig) now() time.Time {
+	if a.NowFunc == nil {
+		return time.Now()
+	}
+	return a.NowFunc()
+}
+
+// hashPassword checks the password against the policy and hashes it with bcrypt.
+func (a *AccountConfig) hashPassword(password string) (string, error) {
+	if err := a.PasswordPolicy.Validate(password); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), mc.VarOr(a.BcryptCost, bcrypt.DefaultCost))
+	if err != nil {
+		return "", errors.Wrap(err, "hash password error")
+	}
+	return string(hash), nil
+}
+
+// PasswordReset is a password reset to be delivered to its user.
+type PasswordReset struct {
+	User      User
+	Token     string
+	Link      string
+	ExpiresAt time.Time
+}
+
+// Notifier delivers password resets, e.g. by email, it is called in a goroutine of its own.
+type Notifier interface {
+	NotifyPasswordReset(reset *PasswordReset) error
+}
+
+// PasswordPolicy is checked when a password is set, the zero value requires 8 to 72 bytes, the most bcrypt accepts.
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+// Validate returns an error of CodePasswordPolicy, its password detail is the first rule the password breaks.
+func (p PasswordPolicy) Validate(password string) error {
+	minLength, maxLength := mc.VarOr(p.MinLength, 8), mc.VarOr(p.MaxLength, 72)
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+
+	var m Msg
+	switch {
+	case len([]rune(password)) < minLength:
+		m = Msg{KeyPasswordTooShort, "password must be at least {min} characters", map[string]any{"min": minLength}}
+	case len(password) > maxLength:
+		m = Msg{KeyPasswordTooLong, "password must be at most {max} bytes", map[string]any{"max": maxLength}}
+	case p.RequireUpper && !upper:
+		m = Msg{Key: KeyPasswordNoUpper, Default: "password must contain an uppercase letter"}
+	case p.RequireLower && !lower:
+		m = Msg{Key: KeyPasswordNoLower, Default: "password must contain a lowercase letter"}
+	case p.RequireDigit && !digit:
+		m = Msg{Key: KeyPasswordNoDigit, Default: "password must contain a digit"}
+	case p.RequireSymbol && !symbol:
+		m = Msg{Key: KeyPasswordNoSymbol, Default: "password must contain a symbol"}
+	default:
+		return nil
+	}
+	return NewError(CodePasswordPolicy, ErrorDetails{"password": m})
+}
+
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type PasswordResetRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+func accountStore(config *CustomAuthConfig) AccountStore {
+	store, ok := config.UserStore.(AccountStore)
+	if !ok {
+		panic("mgin: CustomAuthConfig.Account requires the UserStore to be an AccountStore")
+	}
+	return store
+}
+
+// revokeUserTokens revokes the access tokens issued to the user before now, if the engine is an mjwt.Revoker
+// with revocation enabled, and its refresh tokens.
+func revokeUserTokens(jwt mjwt.Engine, refreshToken *RefreshTokenConfig, userID uint, now time.Time) error {
+	if revoker, ok := jwt.(mjwt.Revoker); ok {
+		if err := revoker.RevokeUser(userID, now); err != nil && !errors.Is(err, mjwt.ErrRevocationDisabled) {
+			return errors.Wrap(err, "revoke error")
+		}
+	}
+	if refreshToken != nil {
+		if err := refreshToken.Store.RevokeUser(userID); err != nil {
+			return errors.Wrap(err, "revoke refresh tokens error")
+		}
+	}
+	return nil
+}
+
+// CreateAuthRegisterHandler creates a user and signs it in as login does.
+func CreateAuthRegisterHandler(config *CustomAuthConfig) HandlerFunc {
+	store := accountStore(config)
+	return func(c *Context) {
+		var data RegisterRequest
+		if !c.MustBindRequest(&data) {
+			return
+		}
+		hash, err := config.Account.hashPassword(data.Password)
+		if err != nil {
+			c.AbortAndWriteHandlerError(err)
+			return
+		}
+		user, err := store.Create(data.Username, hash)
+		if err != nil {
+			if errors.Is(err, ErrUsernameTaken) {
+				c.AbortAndWriteError(0, NewFieldError(CodeUsernameTaken, "username"))
+				return
+			}
+			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "create user error"))
+			return
+		}
+		writeTokens(c, config.Jwt, config.RefreshToken, user, "")
+	}
+}
+
+// CreateAuthPasswordChangeHandler changes the password of the signed in user, given the current one.
+// The other tokens of the user are revoked, and new ones are issued as login does.
+func CreateAuthPasswordChangeHandler(config *CustomAuthConfig) HandlerFunc {
+	store := accountStore(config)
+	return func(c *Context) {
+		var data PasswordChangeRequest
+		if !c.MustBindRequest(&data) {
+			return
+		}
+		user, err := store.Find(c.MustIDContext())
+		if err != nil {
+			c.AbortAndWriteHandlerError(errors.Wrap(err, "find user error"))
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.GetPassword()), []byte(data.CurrentPassword)) != nil {
+			c.AbortAndWriteError(0, NewFieldError(CodePasswordMismatch, "current_password"))
+			return
+		}
+		hash, err := config.Account.hashPassword(data.NewPassword)
+		if err != nil {
+			c.AbortAndWriteHandlerError(err)
+			return
+		}
+		if !setPassword(c, config, store, user.GetID(), hash) {
+			return
+		}
+		writeTokens(c, config.Jwt, config.RefreshToken, user, "")
+	}
+}
+
+// CreateAuthPasswordResetHandler sends a reset link to the user through AccountConfig.Notifier.
+// It responds the same whether the username exists or not, and as fast, the reset is saved and sent in the background.
+func CreateAuthPasswordResetHandler(config *CustomAuthConfig) HandlerFunc {
+	store := accountStore(config)
+	account := config.Account
+	return Typed(func(c *Context, req PasswordResetRequest) (string, error) {
+		user, err := store.FindByUsername(req.Username)
+		if err != nil {
+			if errors.Is(err, ErrUsernameNotFound) {
+				return "OK", nil
+			}
+			return "", errors.Wrap(err, "find user by username error")
+		}
+		token := randomToken(32)
+		reset := &PasswordReset{
+			User:      user,
+			Token:     token,
+			Link:      token,
+			ExpiresAt: account.now().Add(mc.VarOr(account.ResetTokenLease, DefaultResetTokenLease)),
+		}
+		if account.ResetURL != "" {
+			reset.Link = strings.ReplaceAll(account.ResetURL, "{token}", token)
+		}
+		go func() {
+			err := store.SaveResetToken(&PasswordResetToken{
+				Hash:      hashToken(token),
+				UserID:    user.GetID(),
+				ExpiresAt: reset.ExpiresAt,
+			})
+			if err != nil {
+				mlog.Errorf("save reset token error: %v", err)
+				return
+			}
+			if err := account.Notifier.NotifyPasswordReset(reset); err != nil {
+				mlog.Errorf("notify password reset error: %v", err)
+			}
+		}()
+		return "OK", nil
+	})
+}
+
+// CreateAuthPasswordResetConfirmHandler sets a new password with a reset token, which can be used only once,
+// the tokens of the user are revoked. The reset token is consumed once the new password is accepted by the policy,
+// and before it is set, if that fails, a new reset has to be requested.
+func CreateAuthPasswordResetConfirmHandler(config *CustomAuthConfig) HandlerFunc {
+	store := accountStore(config)
+	return func(c *Context) {
+		var data PasswordResetConfirmRequest
+		if !c.MustBindRequest(&data) {
+			return
+		}
+		passwordHash, err := config.Account.hashPassword(data.NewPassword)
+		if err != nil {
+			c.AbortAndWriteHandlerError(err)
+			return
+		}
+		token, err := store.ConsumeResetToken(hashToken(data.Token))
+		if err != nil {
+			if errors.Is(err, ErrPasswordResetTokenNotFound) {
+				c.AbortAndWriteError(0, NewFieldError(CodeResetTokenInvalid, "token"))
+				return
+			}
+			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "consume reset token error"))
+			return
+		}
+		if !token.ExpiresAt.After(config.Account.now()) {
+			c.AbortAndWriteError(0, NewFieldError(CodeResetTokenInvalid, "token"))
+			return
+		}
+		if !setPassword(c, config, store, token.UserID, passwordHash) {
+			return
+		}
+		c.JSON(http.StatusOK, "OK")
+	}
+}
+
+// setPassword sets the password hash of the user and revokes its tokens, it aborts the context on failure.
+func setPassword(c *Context, config *CustomAuthConfig, store AccountStore, userID uint, hash string) bool {
+	if err := store.UpdatePassword(userID, hash); err != nil {
+		c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "update password error"))
+		return false
+	}
+	if err := revokeUserTokens(config.Jwt, config.RefreshToken, userID, jwtNow(config.Jwt)); err != nil {
+		c.AbortAndWriteInternalError(http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAccountStore struct {
+	*testUserStore
+	resetTokens map[string]PasswordResetToken
+}
+
+func (s *testAccountStore) Create(username string, passwordHash string) (User, error) {
+	if _, err := s.FindByUsername(username); err == nil {
+		return nil, ErrUsernameTaken
+	}
+	user := &testUser{ID: uint(len(s.users) + 1), Username: username, Password: passwordHash}
+	s.users = append(s.users, user)
+	return user, nil
+}
+
+func (s *testAccountStore) UpdatePassword(userID uint, passwordHash string) error {
+	user, err := s.Find(userID)
+	if err != nil {
+		return err
+	}
+	user.(*testUser).Password = passwordHash
+	return nil
+}
+
+func (s *testAccountStore) SaveResetToken(token *PasswordResetToken) error {
+	s.resetTokens[token.Hash] = *token
+	return nil
+}
+
+func (s *testAccountStore) ConsumeResetToken(hash string) (*PasswordResetToken, error) {
+	token, ok := s.resetTokens[hash]
+	if !ok {
+		return nil, ErrPasswordResetTokenNotFound
+	}
+	delete(s.resetTokens, hash)
+	return &token, nil
+}
+
+type testNotifier struct {
+	resets chan *PasswordReset
+}
+
+func (n *testNotifier) NotifyPasswordReset(reset *PasswordReset) error {
+	n.resets <- reset
+	return nil
+}
+
+func (n *testNotifier) next(t *testing.T) *PasswordReset {
+	select {
+	case reset := <-n.resets:
+		return reset
+	case <-time.After(time.Second):
+		t.Fatal("password reset not notified")
+		return nil
+	}
+}
+
+func TestAccount(t *testing.T) {
+	assertions := require.New(t)
+
+	now := time.Now()
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	jwt.NowFunc = func() time.Time {
+		return now
+	}
+	jwt.Revocations = mjwt.NewMemoryRevocationStore()
+	notifier := &testNotifier{resets: make(chan *PasswordReset, 2)}
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       jwt,
+		UserStore: &testAccountStore{testUserStore: newTestUserStore(t), resetTokens: map[string]PasswordResetToken{}},
+		Account: &AccountConfig{
+			Registration:   true,
+			PasswordPolicy: PasswordPolicy{RequireDigit: true},
+			Notifier:       notifier,
+			ResetURL:       "https://app.example.com/reset?token={token}",
+			BcryptCost:     bcrypt.MinCost,
+			NowFunc: func() time.Time {
+				return now
+			},
+		},
+	}})
+	post := func(method string, path string, body any, header http.Header, wantStatus int, wantCode int) http.Header {
+		recorder := serveJSON(r, method, path, body, header)
+		assertions.Equal(wantStatus, recorder.Code, recorder.Body.String())
+		if wantCode != 0 {
+			var e E
+			assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+			assertions.Equal(wantCode, e.Code)
+		}
+		return http.Header{"Authorization": {"Bearer " + recorder.Header().Get("Authorization")}}
+	}
+	login := func(password string, wantStatus int) {
+		post(http.MethodPost, AuthLoginPath, map[string]string{"username": "alice", "password": password}, nil, wantStatus, 0)
+	}
+
+	// registration checks the password policy and signs the user in
+	recorder := serveJSON(r, http.MethodPost, AuthRegisterPath, map[string]string{"username": "alice", "password": "short1"}, nil)
+	assertions.Equal(http.StatusUnprocessableEntity, recorder.Code)
+	var e E
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+	assertions.Equal(E{
+		Code:    CodePasswordPolicy,
+		Key:     KeyPasswordPolicy,
+		Message: "password does not meet the policy",
+		Details: ErrorDetails{"password": "password must be at least 8 characters"},
+	}, e)
+	post(http.MethodPost, AuthRegisterPath, map[string]string{"username": "alice", "password": "no digits"}, nil, http.StatusUnprocessableEntity, CodePasswordPolicy)
+	post(http.MethodPost, AuthRegisterPath, map[string]string{"username": "alice"}, nil, http.StatusUnprocessableEntity, http.StatusUnprocessableEntity)
+	header := post(http.MethodPost, AuthRegisterPath, map[string]string{"username": "alice", "password": "password1"}, nil, http.StatusOK, 0)
+	post(http.MethodGet, AuthUserPath, nil, header, http.StatusOK, 0)
+	post(http.MethodPost, AuthRegisterPath, map[string]string{"username": "alice", "password": "password1"}, nil, http.StatusConflict, CodeUsernameTaken)
+
+	// changing the password requires the current one, and revokes the other tokens,
+	// they are revoked by the second they were issued in, so move on to the next one
+	now = now.Add(time.Second)
+	post(http.MethodPut, AuthPasswordPath, map[string]string{"current_password": "wrong", "new_password": "password2"}, header, http.StatusUnprocessableEntity, CodePasswordMismatch)
+	post(http.MethodPut, AuthPasswordPath, map[string]string{"current_password": "password1", "new_password": "short2"}, header, http.StatusUnprocessableEntity, CodePasswordPolicy)
+	newHeader := post(http.MethodPut, AuthPasswordPath, map[string]string{"current_password": "password1", "new_password": "password2"}, header, http.StatusOK, 0)
+	post(http.MethodGet, AuthUserPath, nil, header, http.StatusUnauthorized, CodeTokenRevoked)
+	post(http.MethodGet, AuthUserPath, nil, newHeader, http.StatusOK, 0)
+	login("password1", http.StatusUnprocessableEntity)
+	login("password2", http.StatusOK)
+
+	// a reset is notified only for existing users, but the response is the same
+	post(http.MethodPost, AuthPasswordResetPath, map[string]string{"username": "nobody"}, nil, http.StatusOK, 0)
+	post(http.MethodPost, AuthPasswordResetPath, map[string]string{"username": "alice"}, nil, http.StatusOK, 0)
+	reset := notifier.next(t)
+	assertions.Equal("alice", reset.User.GetUsername())
+	assertions.Equal("https://app.example.com/reset?token="+reset.Token, reset.Link)
+	assertions.True(strings.HasSuffix(reset.Link, reset.Token))
+
+	post(http.MethodPost, AuthPasswordResetConfirmPath, map[string]string{"token": "wrong", "new_password": "password3"}, nil, http.StatusUnprocessableEntity, CodeResetTokenInvalid)
+	// a password the policy refuses does not use the token up
+	post(http.MethodPost, AuthPasswordResetConfirmPath, map[string]string{"token": reset.Token, "new_password": "short"}, nil, http.StatusUnprocessableEntity, CodePasswordPolicy)
+	post(http.MethodPost, AuthPasswordResetConfirmPath, map[string]string{"token": reset.Token, "new_password": "password3"}, nil, http.StatusOK, 0)
+	login("password3", http.StatusOK)
+
+	// reset tokens can be used only once, and expire
+	post(http.MethodPost, AuthPasswordResetConfirmPath, map[string]string{"token": reset.Token, "new_password": "password4"}, nil, http.StatusUnprocessableEntity, CodeResetTokenInvalid)
+	post(http.MethodPost, AuthPasswordResetPath, map[string]string{"username": "alice"}, nil, http.StatusOK, 0)
+	reset = notifier.next(t)
+	now = now.Add(DefaultResetTokenLease)
+	post(http.MethodPost, AuthPasswordResetConfirmPath, map[string]string{"token": reset.Token, "new_password": "password4"}, nil, http.StatusUnprocessableEntity, CodeResetTokenInvalid)
+	login("password3", http.StatusOK)
+}
+
+func TestAccount_Disabled(t *testing.T) {
+	assertions := require.New(t)
+
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       mjwt.NewImpl([]byte("secret"), time.Hour),
+		UserStore: &testAccountStore{testUserStore: newTestUserStore(t), resetTokens: map[string]PasswordResetToken{}},
+		Account:   &AccountConfig{},
+	}})
+
+	// registration and reset are opt-in
+	var paths []string
+	for _, route := range r.Routes() {
+		paths = append(paths, route.Method+" "+route.Path)
+	}
+	assertions.Contains(paths, http.MethodPut+" "+AuthPasswordPath)
+	assertions.NotContains(paths, http.MethodPost+" "+AuthRegisterPath)
+	assertions.NotContains(paths, http.MethodPost+" "+AuthPasswordResetPath)
+	assertions.NotContains(paths, http.MethodPost+" "+AuthPasswordResetConfirmPath)
+
+	assertions.Panics(func() {
+		Custom(CustomConfig{Auth: &CustomAuthConfig{
+			Jwt:       mjwt.NewImpl([]byte("secret"), time.Hour),
+			UserStore: newTestUserStore(t),
+			Account:   &AccountConfig{},
+		}})
+	})
+}
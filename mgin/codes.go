@@ -0,0 +1,236 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Codes of the built-in errors, they are written to E.Code, their first three digits are the http status.
+const (
+	CodeAuthHeaderMalformed   = 40001
+	CodeTokenInvalid          = 40100
+	CodeTokenMissing          = 40101
+	CodeTokenMalformed        = 40102
+	CodeTokenSignatureInvalid = 40103
+	CodeTokenExpired          = 40104
+	CodeTokenNotValidYet      = 40105
+	CodeTokenAudienceInvalid  = 40106
+	CodeTokenRevoked          = 40107
+	CodeRefreshTokenInvalid   = 40108
+	CodeRefreshTokenReused    = 40109
+	CodeMFARequired           = 40110
+	CodeMFATokenInvalid       = 40111
+	CodeInsufficientRole      = 40301
+	CodeInsufficientScope     = 40302
+	CodeCrossOriginRequest    = 40310
+	CodeCSRFTokenInvalid      = 40311
+	CodeUsernameTaken         = 40901
+	CodeMFAAlreadyEnabled     = 40902
+	CodeMFANotEnabled         = 40903
+	CodeUsernameNotFound      = 42201
+	CodePasswordMismatch      = 42202
+	CodeUserNotFound          = 42203
+	CodeInvalidCredentials    = 42204
+	CodePasswordPolicy        = 42205
+	CodeResetTokenInvalid     = 42206
+	CodeMFACodeInvalid        = 42207
+	CodeSettingKeyNotAllowed  = 42210
+	CodeLoginThrottled        = 42901
+	CodeAccountLocked         = 42902
+)
+
+// ErrorCode is a registered application error, Code and Key both identify it and never change once published.
+type ErrorCode struct {
+	// Code is the numeric code, conventionally the http status followed by two digits, e.g. 42201.
+	Code int `json:"code"`
+
+	// Key is the string code, namespaced by its prefix up to the last dot, e.g. auth.username_not_found.
+	Key string `json:"key"`
+
+	// Status is the http status the error is written with.
+	Status int `json:"status"`
+
+	// Message is the default message, the catalogs may translate it by Key.
+	Message string `json:"message"`
+}
+
+// Namespace returns the prefix of Key up to its last dot, it is empty for keys like page_not_found.
+func (ec ErrorCode) Namespace() string {
+	if i := strings.LastIndex(ec.Key, "."); i >= 0 {
+		return ec.Key[:i]
+	}
+	return ""
+}
+
+// New creates an error of the code with details.
+func (ec ErrorCode) New(details ErrorDetails) *E {
+	return &E{
+		Code:    ec.Code,
+		Key:     ec.Key,
+		Message: ec.Message,
+		Details: details,
+	}
+}
+
+// Msg returns the message of the code, e.g. to be used in E.Details.
+func (ec ErrorCode) Msg() Msg {
+	return Msg{Key: ec.Key, Default: ec.Message}
+}
+
+var errorCodesMu sync.RWMutex
+var errorCodes = map[int]ErrorCode{}
+var errorKeys = map[string]int{}
+
+func init() {
+	for _, ec := range []ErrorCode{
+		{http.StatusNotFound, KeyPageNotFound, http.StatusNotFound, "page not found"},
+		{http.StatusMethodNotAllowed, KeyMethodNotAllowed, http.StatusMethodNotAllowed, "method not allowed"},
+		{http.StatusInternalServerError, KeyServerError, http.StatusInternalServerError, "server error"},
+		{CodeAuthHeaderMalformed, KeyAuthHeaderMalformed, http.StatusBadRequest, "malformed auth header"},
+		{CodeTokenInvalid, KeyTokenInvalid, http.StatusUnauthorized, "invalid token"},
+		{CodeTokenMissing, KeyTokenMissing, http.StatusUnauthorized, "auth header missing"},
+		{CodeTokenMalformed, KeyTokenMalformed, http.StatusUnauthorized, "malformed token"},
+		{CodeTokenSignatureInvalid, KeyTokenSignature, http.StatusUnauthorized, "invalid token signature"},
+		{CodeTokenExpired, KeyTokenExpired, http.StatusUnauthorized, "token expired"},
+		{CodeTokenNotValidYet, KeyTokenNotValidYet, http.StatusUnauthorized, "token not valid yet"},
+		{CodeTokenAudienceInvalid, KeyTokenAudience, http.StatusUnauthorized, "invalid token audience"},
+		{CodeTokenRevoked, KeyTokenRevoked, http.StatusUnauthorized, "token revoked"},
+		{CodeRefreshTokenInvalid, KeyRefreshTokenInvalid, http.StatusUnauthorized, "invalid refresh token"},
+		{CodeRefreshTokenReused, KeyRefreshTokenReused, http.StatusUnauthorized, "refresh token reused"},
+		{CodeMFARequired, KeyMFARequired, http.StatusUnauthorized, "two-factor authentication required"},
+		{CodeMFATokenInvalid, KeyMFATokenInvalid, http.StatusUnauthorized, "invalid or expired mfa token"},
+		{CodeInsufficientRole, KeyInsufficientRoles, http.StatusForbidden, "missing required roles: {missing}"},
+		{CodeInsufficientScope, KeyInsufficientScopes, http.StatusForbidden, "missing required scopes: {missing}"},
+		{CodeCrossOriginRequest, KeyCrossOriginRequest, http.StatusForbidden, "cross-origin request not allowed"},
+		{CodeCSRFTokenInvalid, KeyCSRFTokenInvalid, http.StatusForbidden, "invalid csrf token"},
+		{CodeUsernameTaken, KeyUsernameTaken, http.StatusConflict, "username already taken"},
+		{CodeMFAAlreadyEnabled, KeyMFAAlreadyEnabled, http.StatusConflict, "two-factor authentication already enabled"},
+		{CodeMFANotEnabled, KeyMFANotEnabled, http.StatusConflict, "two-factor authentication not enabled"},
+		{CodeUsernameNotFound, KeyUsernameNotFound, http.StatusUnprocessableEntity, "username not exist"},
+		{CodePasswordMismatch, KeyPasswordMismatch, http.StatusUnprocessableEntity, "password not match"},
+		{CodeUserNotFound, KeyUserNotFound, http.StatusUnprocessableEntity, "user not found"},
+		{CodeInvalidCredentials, KeyInvalidCredentials, http.StatusUnprocessableEntity, "invalid username or password"},
+		{CodePasswordPolicy, KeyPasswordPolicy, http.StatusUnprocessableEntity, "password does not meet the policy"},
+		{CodeResetTokenInvalid, KeyResetTokenInvalid, http.StatusUnprocessableEntity, "invalid or expired reset token"},
+		{CodeMFACodeInvalid, KeyMFACodeInvalid, http.StatusUnprocessableEntity, "invalid verification code"},
+		{CodeSettingKeyNotAllowed, KeySettingKeyNotAllowed, http.StatusUnprocessableEntity, "key not allowed"},
+		{CodeLoginThrottled, KeyLoginThrottled, http.StatusTooManyRequests, "too many failed logins, retry in {retry_after} seconds"},
+		{CodeAccountLocked, KeyAccountLocked, http.StatusTooManyRequests, "too many failed logins, locked for {retry_after} seconds"},
+	} {
+		if err := RegisterErrorCode(ec); err != nil {
+			panic(err)
+		}
+		DefaultCatalog["en"][ec.Key] = ec.Message
+	}
+}
+
+// RegisterErrorCode registers an application error code, neither its Code nor its Key may be registered already.
+// Status defaults to the status derived from Code, see E.Status.
+func RegisterErrorCode(ec ErrorCode) error {
+	if ec.Code == 0 || ec.Key == "" {
+		return errors.Errorf("error code %d %q: code and key are required", ec.Code, ec.Key)
+	}
+	if ec.Status == 0 {
+		ec.Status = (&E{Code: ec.Code}).Status()
+	}
+
+	errorCodesMu.Lock()
+	defer errorCodesMu.Unlock()
+	if existing, ok := errorCodes[ec.Code]; ok {
+		return errors.Errorf("error code %d is already registered as %q", ec.Code, existing.Key)
+	}
+	if code, ok := errorKeys[ec.Key]; ok {
+		return errors.Errorf("error key %q is already registered as %d", ec.Key, code)
+	}
+	errorCodes[ec.Code] = ec
+	errorKeys[ec.Key] = ec.Code
+	return nil
+}
+
+// LookupErrorCode returns the registered error code, ok is false if it is not registered.
+func LookupErrorCode(code int) (ec ErrorCode, ok bool) {
+	errorCodesMu.RLock()
+	defer errorCodesMu.RUnlock()
+	ec, ok = errorCodes[code]
+	return ec, ok
+}
+
+// LookupErrorKey returns the error code registered with key, ok is false if there is none.
+func LookupErrorKey(key string) (ec ErrorCode, ok bool) {
+	errorCodesMu.RLock()
+	defer errorCodesMu.RUnlock()
+	code, ok := errorKeys[key]
+	if !ok {
+		return ErrorCode{}, false
+	}
+	return errorCodes[code], true
+}
+
+// NewError creates an error of a registered code with details, an unregistered code gets only its status text.
+func NewError(code int, details ErrorDetails) *E {
+	if ec, ok := LookupErrorCode(code); ok {
+		return ec.New(details)
+	}
+	e := &E{Code: code, Details: details}
+	e.Message = http.StatusText(e.Status())
+	return e
+}
+
+// NewFieldError creates an error of a registered code, with its message as the details of field.
+func NewFieldError(code int, field string) *E {
+	ec, _ := LookupErrorCode(code)
+	return NewError(code, ErrorDetails{field: ec.Msg()})
+}
+
+// ErrorCodes returns the registered error codes sorted by code.
+func ErrorCodes() []ErrorCode {
+	errorCodesMu.RLock()
+	defer errorCodesMu.RUnlock()
+	codes := make([]ErrorCode, 0, len(errorCodes))
+	for _, ec := range errorCodes {
+		codes = append(codes, ec)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i].Code < codes[j].Code
+	})
+	return codes
+}
+
+// ErrorCodeEntry is an error code as exported by ErrorCodesJSON.
+type ErrorCodeEntry struct {
+	ErrorCode
+	Namespace string `json:"namespace,omitempty"`
+
+	// Messages are the messages of the code by language.
+	Messages map[string]string `json:"messages,omitempty"`
+}
+
+// ErrorCodesJSON exports the registered error codes as a JSON array sorted by code, e.g. for generating client SDKs,
+// with their messages in languages looked up in catalog, if not nil, and then DefaultCatalog.
+func ErrorCodesJSON(catalog Catalog, languages ...string) ([]byte, error) {
+	codes := ErrorCodes()
+	entries := make([]ErrorCodeEntry, len(codes))
+	for i, ec := range codes {
+		entries[i] = ErrorCodeEntry{ErrorCode: ec, Namespace: ec.Namespace()}
+		for _, lang := range languages {
+			message, ok := "", false
+			if catalog != nil {
+				message, ok = catalog.Message(lang, ec.Key)
+			}
+			if !ok {
+				message, ok = DefaultCatalog.Message(lang, ec.Key)
+			}
+			if ok {
+				if entries[i].Messages == nil {
+					entries[i].Messages = map[string]string{}
+				}
+				entries[i].Messages[lang] = message
+			}
+		}
+	}
+	return json.MarshalIndent(entries, "", "  ")
+}
@@ -0,0 +1,107 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestErrorCodes(t *testing.T) {
+	assertions := require.New(t)
+
+	ec, ok := LookupErrorCode(CodePasswordMismatch)
+	assertions.True(ok)
+	assertions.Equal(KeyPasswordMismatch, ec.Key)
+	assertions.Equal(http.StatusUnprocessableEntity, ec.Status)
+	assertions.Equal("auth", ec.Namespace())
+	ec, ok = LookupErrorKey(KeySettingKeyNotAllowed)
+	assertions.True(ok)
+	assertions.Equal(CodeSettingKeyNotAllowed, ec.Code)
+
+	// the English catalog has the messages the built-in codes are registered with
+	message, ok := DefaultCatalog.Message("en", KeySettingKeyNotAllowed)
+	assertions.True(ok)
+	assertions.Equal(ec.Message, message)
+
+	// codes and keys are unique, the status defaults to the one derived from the code
+	assertions.NotNil(RegisterErrorCode(ErrorCode{Code: CodePasswordMismatch, Key: "test.other"}))
+	assertions.NotNil(RegisterErrorCode(ErrorCode{Code: 40999, Key: KeyPasswordMismatch}))
+	assertions.NotNil(RegisterErrorCode(ErrorCode{Code: 40999}))
+	assertions.Nil(RegisterErrorCode(ErrorCode{Code: 40999, Key: "test.conflict", Message: "conflict"}))
+	assertions.Nil(RegisterErrorCode(ErrorCode{Code: 40998, Key: "test.teapot", Status: http.StatusTeapot, Message: "teapot"}))
+	ec, _ = LookupErrorCode(40999)
+	assertions.Equal(http.StatusConflict, ec.Status)
+	assertions.Equal(http.StatusTeapot, NewError(40998, nil).Status())
+
+	// a registered code can be written directly, with its status
+	r := New()
+	r.GET("/teapot", func(c *Context) {
+		c.AbortAndWriteError(0, 40998)
+	})
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/teapot", nil))
+	assertions.Equal(http.StatusTeapot, recorder.Code)
+	var e E
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+	assertions.Equal(E{Code: 40998, Key: "test.teapot", Message: "teapot"}, e)
+
+	data, err := ErrorCodesJSON(nil, "en", "zh")
+	assertions.Nil(err)
+	var entries []ErrorCodeEntry
+	assertions.Nil(json.Unmarshal(data, &entries))
+	var found bool
+	for i, entry := range entries {
+		if i > 0 {
+			assertions.Less(entries[i-1].Code, entry.Code)
+		}
+		if entry.Code == CodeUsernameNotFound {
+			found = true
+			assertions.Equal("auth", entry.Namespace)
+			assertions.Equal(http.StatusUnprocessableEntity, entry.Status)
+			assertions.Equal(map[string]string{"en": "username not exist", "zh": "用户名不存在"}, entry.Messages)
+		}
+	}
+	assertions.True(found)
+}
+
+func TestErrorCodes_Login(t *testing.T) {
+	assertions := require.New(t)
+
+	gin.SetMode(gin.TestMode)
+	r := Custom(CustomConfig{
+		Auth: &CustomAuthConfig{
+			Jwt:       mjwt.NewImpl([]byte("secret"), time.Hour),
+			UserStore: newTestUserStore(t),
+		},
+	})
+
+	// a wrong username and a wrong password are told apart by code
+	for _, tt := range []struct {
+		body map[string]string
+		want E
+	}{
+		{map[string]string{"username": "nobody", "password": "password"}, E{
+			Code:    CodeUsernameNotFound,
+			Key:     KeyUsernameNotFound,
+			Message: "username not exist",
+			Details: ErrorDetails{"username": "username not exist"},
+		}},
+		{map[string]string{"username": "admin", "password": "wrong"}, E{
+			Code:    CodePasswordMismatch,
+			Key:     KeyPasswordMismatch,
+			Message: "password not match",
+			Details: ErrorDetails{"password": "password not match"},
+		}},
+	} {
+		recorder := serveJSON(r, http.MethodPost, AuthLoginPath, tt.body, nil)
+		assertions.Equal(http.StatusUnprocessableEntity, recorder.Code)
+		var e E
+		assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+		assertions.Equal(tt.want, e)
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/kacifer/mc"
+	"github.com/kacifer/mc/mjwt"
 	"github.com/kacifer/mc/mlog"
-	"github.com/pkg/errors"
 	"net/http"
 )
 
 const IDKey = "id"
 
+// ClaimsKey is the context key of the mjwt.Claims of an authenticated request.
+const ClaimsKey = "claims"
+
 const IDQuery = "id"
 
 const IDParam = "id"
@@ -21,7 +24,7 @@ type Context struct {
 
 func (c *Context) MustBindQuery(obj any) (ok bool) {
 	if err := c.Context.ShouldBindQuery(obj); err != nil {
-		c.AbortAndWriteError(http.StatusBadRequest, errors.Wrap(err, "query decode error"))
+		c.abortAndWriteBindError(err, obj, "query decode error")
 		return false
 	}
 	return true
@@ -29,7 +32,7 @@ func (c *Context) MustBindQuery(obj any) (ok bool) {
 
 func (c *Context) MustBindJSON(obj any) (ok bool) {
 	if err := c.Context.ShouldBindJSON(obj); err != nil {
-		c.AbortAndWriteError(http.StatusBadRequest, errors.Wrap(err, "JSON decode error"))
+		c.abortAndWriteBindError(err, obj, "JSON decode error")
 		return false
 	}
 	return true
@@ -83,6 +86,31 @@ func (c *Context) IDContext() (uint, bool) {
 	return c.UintContext(IDKey)
 }
 
+// Claims returns the claims of the authenticated request, nil if the request is not authenticated.
+func (c *Context) Claims() mjwt.Claims {
+	claims, _ := c.Value(ClaimsKey).(mjwt.Claims)
+	return claims
+}
+
+// BindClaims decodes the claims of the authenticated request into out, a pointer to a struct or map.
+func (c *Context) BindClaims(out any) error {
+	claims := c.Claims()
+	if claims == nil {
+		return mjwt.ErrAuthHeaderMissing
+	}
+	return claims.Decode(out)
+}
+
+// HasRole reports whether the token of the request carries the role.
+func (c *Context) HasRole(role string) bool {
+	return mc.SliceContains(c.Claims().Roles(), role)
+}
+
+// HasScope reports whether the token of the request carries the scope.
+func (c *Context) HasScope(scope string) bool {
+	return mc.SliceContains(c.Claims().Scopes(), scope)
+}
+
 func (c *Context) UintQuery(key string) uint {
 	return mc.StringToUint(c.Query(key))
 }
@@ -99,27 +127,49 @@ func (c *Context) IDParam() uint {
 	return c.UintParam(IDParam)
 }
 
-// AbortAndWriteError aborts the context and write standard error response
+// AbortAndWriteError aborts the context and write standard error response,
+// err can also be a registered error code or ErrorCode, code 0 means the status registered for the error.
 func (c *Context) AbortAndWriteError(code int, err any) {
+	var e *E
 	switch err.(type) {
 	case *E:
-		c.AbortWithStatusJSON(code, err.(*E))
+		e = c.localize(err.(*E))
+	case ErrorCode:
+		e = c.localize(err.(ErrorCode).New(nil))
+	case int:
+		if ec, ok := LookupErrorCode(err.(int)); ok {
+			e = c.localize(ec.New(nil))
+		} else {
+			e = &E{
+				Code:    code,
+				Message: fmt.Sprintf("%v", err),
+			}
+		}
 	case error:
-		c.AbortWithStatusJSON(code, &E{
+		e = &E{
 			Code:    code,
 			Message: err.(error).Error(),
-		})
+		}
 	case string:
-		c.AbortWithStatusJSON(code, &E{
+		e = &E{
 			Code:    code,
 			Message: err.(string),
-		})
+		}
 	default:
-		c.AbortWithStatusJSON(code, &E{
+		e = &E{
 			Code:    code,
 			Message: fmt.Sprintf("%v", err),
-		})
+		}
+	}
+	if code == 0 {
+		code = e.Status()
 	}
+	if c.wantsProblem() {
+		c.Header("Content-Type", ProblemContentType)
+		c.AbortWithStatusJSON(code, NewProblem(code, e, c.Request.URL.RequestURI(), c.problemConfig()))
+		return
+	}
+	c.AbortWithStatusJSON(code, e)
 }
 
 // AbortAndWriteInternalError aborts the context, push error to error stack and write standard error response,
@@ -131,7 +181,11 @@ func (c *Context) AbortAndWriteInternalError(code int, err any) {
 		return
 	} else {
 		// hide error message if not debugging
-		c.AbortAndWriteError(code, "server error")
+		c.AbortAndWriteError(code, &E{
+			Code:    code,
+			Key:     KeyServerError,
+			Message: "server error",
+		})
 	}
 }
 
@@ -146,18 +200,7 @@ func (c *Context) AbortAndWriteInvalidInputError(e *E) {
 
 // AbortAndWriteInvalidInputDetails aborts the context and write standard error response with invalid input details
 func (c *Context) AbortAndWriteInvalidInputDetails(details map[string]any) {
-	message := "invalid input"
-	if len(details) > 0 {
-		for _, v := range details {
-			message = fmt.Sprintf("%v", v)
-			break
-		}
-	}
-	c.AbortAndWriteError(http.StatusUnprocessableEntity, &E{
-		Code:    http.StatusUnprocessableEntity,
-		Message: message,
-		Details: details,
-	})
+	c.AbortAndWriteInvalidInputError(NewInvalidInputError(details))
 }
 
 func WrapContext(c *gin.Context) *Context {
@@ -179,6 +222,9 @@ func WrapHandlers(f []gin.HandlerFunc) []HandlerFunc {
 }
 
 func AdaptHandler(f HandlerFunc) gin.HandlerFunc {
+	if marker, ok := markers[funcPointer(f)]; ok {
+		return marker
+	}
 	return func(c *gin.Context) {
 		f(WrapContext(c))
 	}
@@ -21,7 +21,7 @@ func CreateTestContext() (
 	recorder := httptest.NewRecorder()
 	gc, ge := gin.CreateTestContext(recorder)
 	c := &Context{gc}
-	e := &Engine{ge}
+	e := &Engine{Engine: ge}
 	return recorder, c, e
 }
 
@@ -116,6 +116,7 @@ func TestContext_AbortAndWriteInternalError(t *testing.T) {
 		assertions.Nil(err)
 		expected, err := json.Marshal(&E{
 			Code:    http.StatusInternalServerError,
+			Key:     KeyServerError,
 			Message: "server error",
 		})
 		assertions.Nil(err)
@@ -0,0 +1,113 @@
+package mgin
+
+import (
+	"github.com/kacifer/mc"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const DefaultTokenCookieName = "access_token"
+
+// CookieKey is the context key of the *CookieConfig set by CreateCookieMiddleware.
+const CookieKey = "cookie"
+
+// CookieConfig makes the auth handlers keep the access token in an HttpOnly cookie instead of the Authorization header,
+// the auth middleware reads it with CookieTokenSource.
+//
+// Browsers do not send SameSite cookies along with cross-site requests, besides, unsafe requests authenticated
+// by the cookie are rejected if their Origin, or Referer, is neither the host of the request nor one of TrustedOrigins.
+type CookieConfig struct {
+	// Name defaults to DefaultTokenCookieName.
+	Name string
+
+	// Path defaults to "/".
+	Path   string
+	Domain string
+
+	// MaxAge is the lifetime of the cookie, it is a session cookie if zero.
+	MaxAge time.Duration
+
+	// Insecure drops the Secure attribute, e.g. for development over plain http.
+	Insecure bool
+
+	// SameSite defaults to http.SameSiteStrictMode.
+	SameSite http.SameSite
+
+	// TrustedOrigins may send unsafe requests besides the host of the request, e.g. https://app.example.com.
+	TrustedOrigins []string
+}
+
+func (config *CookieConfig) name() string {
+	return mc.VarOr(config.Name, DefaultTokenCookieName)
+}
+
+func (config *CookieConfig) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     config.name(),
+		Value:    value,
+		Path:     mc.VarOr(config.Path, "/"),
+		Domain:   config.Domain,
+		MaxAge:   maxAge,
+		Secure:   !config.Insecure,
+		HttpOnly: true,
+		SameSite: mc.VarOr(config.SameSite, http.SameSiteStrictMode),
+	}
+}
+
+// CreateCookieMiddleware makes the auth handlers write the access token to a cookie, see CookieConfig.
+func CreateCookieMiddleware(config *CookieConfig) HandlerFunc {
+	return func(c *Context) {
+		c.Set(CookieKey, config)
+		c.Next()
+	}
+}
+
+func (c *Context) cookieConfig() *CookieConfig {
+	config, _ := c.Value(CookieKey).(*CookieConfig)
+	return config
+}
+
+// writeAccessToken writes the token to the cookie if CreateCookieMiddleware is in use, otherwise to the Authorization header,
+// inCookie tells which.
+func (c *Context) writeAccessToken(tokenString string) (inCookie bool) {
+	config := c.cookieConfig()
+	if config == nil {
+		c.Header("Authorization", tokenString)
+		return false
+	}
+	http.SetCookie(c.Writer, config.cookie(tokenString, int(config.MaxAge/time.Second)))
+	return true
+}
+
+// clearAccessToken expires the cookie if CreateCookieMiddleware is in use.
+func (c *Context) clearAccessToken() {
+	if config := c.cookieConfig(); config != nil {
+		http.SetCookie(c.Writer, config.cookie("", -1))
+	}
+}
+
+// checkOrigin aborts unsafe requests which come from another origin, requests without Origin and Referer pass.
+func (c *Context) checkOrigin() bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		origin = c.GetHeader("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host != "" {
+		if u.Host == c.Request.Host {
+			return true
+		}
+		if config := c.cookieConfig(); config != nil && mc.SliceContains(config.TrustedOrigins, u.Scheme+"://"+u.Host) {
+			return true
+		}
+	}
+	c.AbortAndWriteError(0, CodeCrossOriginRequest)
+	return false
+}
@@ -0,0 +1,157 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCookieAuth(t *testing.T) {
+	assertions := require.New(t)
+
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	jwt.Revocations = mjwt.NewMemoryRevocationStore()
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       jwt,
+		UserStore: newTestUserStore(t),
+		Cookie:    &CookieConfig{MaxAge: time.Hour, TrustedOrigins: []string{"https://app.example.com"}},
+	}})
+
+	// login sets the cookie instead of the Authorization header
+	recorder := serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{"username": "admin", "password": "password"}, nil)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	assertions.Empty(recorder.Header().Get("Authorization"))
+	cookie := findCookie(recorder, DefaultTokenCookieName)
+	assertions.NotNil(cookie)
+	assertions.True(cookie.HttpOnly)
+	assertions.True(cookie.Secure)
+	assertions.Equal(http.SameSiteStrictMode, cookie.SameSite)
+	assertions.Equal(3600, cookie.MaxAge)
+	csrfToken := recorder.Header().Get(DefaultCSRFHeaderName)
+	assertions.NotEmpty(csrfToken)
+	header := http.Header{"Cookie": {cookie.Name + "=" + cookie.Value + "; " + DefaultCSRFCookieName + "=" + csrfToken}}
+
+	recorder = serveJSON(r, http.MethodGet, AuthUserPath, nil, header)
+	assertions.Equal(http.StatusOK, recorder.Code)
+
+	// the Bearer header still works
+	recorder = serveJSON(r, http.MethodGet, AuthUserPath, nil, http.Header{"Authorization": {"Bearer " + cookie.Value}})
+	assertions.Equal(http.StatusOK, recorder.Code)
+
+	// unsafe requests with the cookie must come from the same or a trusted origin
+	recorder = serveJSON(r, http.MethodPost, AuthLogoutPath, nil, http.Header{
+		"Cookie": header["Cookie"],
+		"Origin": {"https://evil.example.com"},
+	})
+	assertions.Equal(http.StatusForbidden, recorder.Code)
+	var e E
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+	assertions.Equal(CodeCrossOriginRequest, e.Code)
+
+	recorder = serveJSON(r, http.MethodPost, AuthLogoutPath, nil, http.Header{
+		"Cookie":  header["Cookie"],
+		"Referer": {"https://evil.example.com/page"},
+	})
+	assertions.Equal(http.StatusForbidden, recorder.Code)
+
+	recorder = serveJSON(r, http.MethodPost, AuthLogoutPath, nil, http.Header{
+		"Cookie":              header["Cookie"],
+		"Origin":              {"https://app.example.com"},
+		DefaultCSRFHeaderName: {csrfToken},
+	})
+	assertions.Equal(http.StatusOK, recorder.Code)
+
+	// logout clears the cookie
+	cookie = findCookie(recorder, DefaultTokenCookieName)
+	assertions.NotNil(cookie)
+	assertions.Empty(cookie.Value)
+	assertions.Less(cookie.MaxAge, 0)
+	assertions.Equal(http.StatusUnauthorized, serveJSON(r, http.MethodGet, AuthUserPath, nil, header).Code)
+}
+
+func TestCookieAuth_LogoutWithoutRevocation(t *testing.T) {
+	assertions := require.New(t)
+
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       mjwt.NewImpl([]byte("secret"), time.Hour),
+		UserStore: newTestUserStore(t),
+		Cookie:    &CookieConfig{},
+	}})
+
+	recorder := serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{"username": "admin", "password": "password"}, nil)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	cookie := findCookie(recorder, DefaultTokenCookieName)
+	assertions.NotNil(cookie)
+	csrfToken := recorder.Header().Get(DefaultCSRFHeaderName)
+
+	recorder = serveJSON(r, http.MethodPost, AuthLogoutPath, nil, http.Header{
+		"Cookie":              {cookie.Name + "=" + cookie.Value + "; " + DefaultCSRFCookieName + "=" + csrfToken},
+		DefaultCSRFHeaderName: {csrfToken},
+	})
+	assertions.Equal(http.StatusOK, recorder.Code)
+	cookie = findCookie(recorder, DefaultTokenCookieName)
+	assertions.NotNil(cookie)
+	assertions.Empty(cookie.Value)
+	assertions.Less(cookie.MaxAge, 0)
+}
+
+func findCookie(recorder *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestTokenSources(t *testing.T) {
+	assertions := require.New(t)
+
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	r := Custom(CustomConfig{
+		Auth: &CustomAuthConfig{
+			Jwt:          jwt,
+			UserStore:    newTestUserStore(t),
+			TokenSources: []TokenSource{{In: TokenInHeader, Name: "X-Token"}, QueryTokenSource("access_token")},
+		},
+		OpenAPI: &OpenAPIConfig{},
+	})
+	signed, err := jwt.SignedStringForID(1)
+	assertions.Nil(err)
+
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodGet, AuthUserPath, nil, http.Header{"X-Token": {signed}}).Code)
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodGet, AuthUserPath, nil, http.Header{"X-Token": {"Bearer " + signed}}).Code)
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodGet, AuthUserPath+"?access_token="+signed, nil, nil).Code)
+
+	// the Authorization header is not a source
+	recorder := serveJSON(r, http.MethodGet, AuthUserPath, nil, http.Header{"Authorization": {"Bearer " + signed}})
+	assertions.Equal(http.StatusUnauthorized, recorder.Code)
+
+	// the sources are documented as security schemes
+	recorder = serveJSON(r, http.MethodGet, OpenAPIPath, nil, nil)
+	var doc OpenAPIDocument
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &doc))
+	assertions.Equal(map[string]*OpenAPISecurityScheme{
+		"headerAuth_X-Token":     {Type: "apiKey", In: TokenInHeader, Name: "X-Token"},
+		"queryAuth_access_token": {Type: "apiKey", In: TokenInQuery, Name: "access_token"},
+	}, doc.Components.SecuritySchemes)
+}
+
+func TestAuthMiddleware_Scheme(t *testing.T) {
+	assertions := require.New(t)
+
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{Jwt: jwt, UserStore: newTestUserStore(t)}})
+	signed, err := jwt.SignedStringForID(1)
+	assertions.Nil(err)
+
+	// only the Bearer scheme is accepted, case-insensitively
+	recorder := serveJSON(r, http.MethodGet, AuthUserPath, nil, http.Header{"Authorization": {"Basic " + signed}})
+	assertions.Equal(http.StatusBadRequest, recorder.Code)
+	recorder = serveJSON(r, http.MethodGet, AuthUserPath, nil, http.Header{"Authorization": {"bearer " + signed}})
+	assertions.Equal(http.StatusOK, recorder.Code)
+}
@@ -2,22 +2,69 @@ package mgin
 
 import (
 	"fmt"
+	"net/http"
+	"sort"
 	"strings"
 )
 
 type ErrorDetails map[string]any
 
 type E struct {
-	// Code is the error code, if not specified, it will be the same as the http status code.
+	// Code is the application error code, see RegisterErrorCode, if not specified, it will be the same as the http status code.
 	Code int `json:"code"`
 
-	// Message is the error message.
+	// Key is the stable machine-readable key of the error, it selects the message from the catalogs.
+	Key string `json:"key,omitempty"`
+
+	// Message is the error message, in the default language if Key is set.
 	Message string `json:"message"`
 
+	// Params are substituted for the {name} placeholders of the message.
+	Params map[string]any `json:"-"`
+
 	// Details can be used to provide more details about the error, such as the invalid form fields.
 	Details ErrorDetails `json:"details,omitempty"`
 }
 
+// NewInvalidInputError creates an unprocessable entity error, its message and key are those of the first field by name.
+func NewInvalidInputError(details ErrorDetails) *E {
+	message := "invalid input"
+	keys := make([]string, 0, len(details))
+	for k := range details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	e := &E{
+		Code:    http.StatusUnprocessableEntity,
+		Message: message,
+		Details: details,
+	}
+	if len(keys) > 0 {
+		if m, ok := details[keys[0]].(Msg); ok {
+			e.Key, e.Message, e.Params = m.Key, m.Default, m.Params
+		} else {
+			e.Message = fmt.Sprintf("%v", details[keys[0]])
+		}
+	}
+	return e
+}
+
+// Status returns the http status of the error, the registered status of Code, Code itself if it is a status,
+// or its first three digits for codes like 40301, http.StatusBadRequest otherwise.
+func (e *E) Status() int {
+	if ec, ok := LookupErrorCode(e.Code); ok {
+		return ec.Status
+	}
+	switch {
+	case e.Code >= 100 && e.Code < 600:
+		return e.Code
+	case e.Code >= 10000 && e.Code < 60000:
+		return e.Code / 100
+	default:
+		return http.StatusBadRequest
+	}
+}
+
 func (e *E) Error() string {
 	var details []string
 	if e.Details != nil {
@@ -0,0 +1,110 @@
+package mgin
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// RouterGroup groups routes under a path prefix with shared middlewares, create it with Engine.Group.
+type RouterGroup struct {
+	*gin.RouterGroup
+	engine *Engine
+}
+
+// Use adds middlewares to the group, they run for the routes registered on it afterwards.
+func (group *RouterGroup) Use(middleware ...HandlerFunc) *RouterGroup {
+	group.RouterGroup.Use(AdaptHandlers(middleware)...)
+	return group
+}
+
+// Group creates a nested group, it inherits the path and the middlewares of this group.
+func (group *RouterGroup) Group(relativePath string, handlers ...HandlerFunc) *RouterGroup {
+	return &RouterGroup{
+		RouterGroup: group.RouterGroup.Group(relativePath, AdaptHandlers(handlers)...),
+		engine:      group.engine,
+	}
+}
+
+// handle registers the route and records it for the OpenAPI document.
+func (group *RouterGroup) handle(httpMethods []string, relativePath string, handlers []HandlerFunc) {
+	adapted := AdaptHandlers(handlers)
+	var handlerNames []string
+	for _, h := range append(group.Handlers, adapted...) {
+		handlerNames = append(handlerNames, funcName(h))
+	}
+	for _, method := range httpMethods {
+		group.RouterGroup.Handle(method, relativePath, adapted...)
+	}
+	group.engine.registry().add(httpMethods[0], joinPaths(group.BasePath(), relativePath), routeAccess(handlerNames))
+}
+
+// Document sets the OpenAPI operation of the route, the route may be registered before or after.
+func (group *RouterGroup) Document(httpMethod, relativePath string, op Operation) {
+	group.engine.registry().document(httpMethod, joinPaths(group.BasePath(), relativePath), op)
+}
+
+func (group *RouterGroup) Handle(httpMethod, relativePath string, handlers ...HandlerFunc) {
+	group.handle([]string{httpMethod}, relativePath, handlers)
+}
+
+func (group *RouterGroup) POST(relativePath string, handlers ...HandlerFunc) {
+	group.handle([]string{http.MethodPost}, relativePath, handlers)
+}
+
+func (group *RouterGroup) GET(relativePath string, handlers ...HandlerFunc) {
+	group.handle([]string{http.MethodGet}, relativePath, handlers)
+}
+
+func (group *RouterGroup) DELETE(relativePath string, handlers ...HandlerFunc) {
+	group.handle([]string{http.MethodDelete}, relativePath, handlers)
+}
+
+func (group *RouterGroup) PATCH(relativePath string, handlers ...HandlerFunc) {
+	group.handle([]string{http.MethodPatch}, relativePath, handlers)
+}
+
+func (group *RouterGroup) PUT(relativePath string, handlers ...HandlerFunc) {
+	group.handle([]string{http.MethodPut}, relativePath, handlers)
+}
+
+func (group *RouterGroup) OPTIONS(relativePath string, handlers ...HandlerFunc) {
+	group.handle([]string{http.MethodOptions}, relativePath, handlers)
+}
+
+func (group *RouterGroup) HEAD(relativePath string, handlers ...HandlerFunc) {
+	group.handle([]string{http.MethodHead}, relativePath, handlers)
+}
+
+// Any registers the route for every method, it is documented as a GET operation.
+func (group *RouterGroup) Any(relativePath string, handlers ...HandlerFunc) {
+	group.handle(anyMethods, relativePath, handlers)
+}
+
+// Match registers the route for the methods, it is documented as an operation of the first one.
+func (group *RouterGroup) Match(methods []string, relativePath string, handlers ...HandlerFunc) {
+	if len(methods) > 0 {
+		group.handle(methods, relativePath, handlers)
+	}
+}
+
+func (group *RouterGroup) Static(relativePath, root string) {
+	group.RouterGroup.Static(relativePath, root)
+}
+
+func (group *RouterGroup) StaticFS(relativePath string, fs http.FileSystem) {
+	group.RouterGroup.StaticFS(relativePath, fs)
+}
+
+func (group *RouterGroup) StaticFile(relativePath, filepath string) {
+	group.RouterGroup.StaticFile(relativePath, filepath)
+}
+
+func (group *RouterGroup) StaticFileFS(relativePath, filepath string, fs http.FileSystem) {
+	group.RouterGroup.StaticFileFS(relativePath, filepath, fs)
+}
+
+var anyMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodHead, http.MethodOptions, http.MethodDelete, http.MethodConnect,
+	http.MethodTrace,
+}
@@ -1,31 +1,189 @@
 package mgin
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"github.com/kacifer/mc"
 	"github.com/kacifer/mc/mjwt"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+const DefaultRefreshTokenLease = 30 * 24 * time.Hour
+
+// RefreshTokenConfig enables refresh tokens, login then responds with a TokenPair,
+// and each refresh token can be exchanged only once for a new pair.
+type RefreshTokenConfig struct {
+	Store RefreshTokenStore
+
+	// Lease is how long a refresh token is valid, defaults to DefaultRefreshTokenLease.
+	Lease time.Duration
+
+	NowFunc func() time.Time
+}
+
+func (r *RefreshTokenConfig) now() time.Time {
+	if r.NowFunc == nil {
+		return time.Now()
+	}
+	return r.NowFunc()
+}
+
+// issue creates and saves a refresh token, a new family is started if familyID is empty.
+func (r *RefreshTokenConfig) issue(userID uint, familyID string) (string, error) {
+	if familyID == "" {
+		familyID = randomToken(16)
+	}
+	token := randomToken(32)
+	err := r.Store.Save(&RefreshToken{
+		Hash:      hashToken(token),
+		UserID:    userID,
+		FamilyID:  familyID,
+		ExpiresAt: r.now().Add(mc.VarOr(r.Lease, DefaultRefreshTokenLease)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func randomBytes(size int) []byte {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func randomToken(size int) string {
+	return base64.RawURLEncoding.EncodeToString(randomBytes(size))
+}
+
+// hashToken returns the SHA-256 hex digest of a refresh, reset or recovery token, stores never see the token itself.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutRequest struct {
+	All bool `form:"all" json:"-"`
+}
+
+// SettingGetRequest selects a single setting by Key, or several by Keys, separated by commas.
+type SettingGetRequest struct {
+	Key  string `form:"key" json:"-"`
+	Keys string `form:"keys" json:"-"`
+}
+
+type SettingSetRequest struct {
+	Key   string `form:"key" json:"-"`
+	Value string `form:"-" json:"value"`
+}
+
+// TokenPair is the response of login and refresh with refresh tokens enabled,
+// AccessToken is empty if it is written to a cookie, see CookieConfig.
+type TokenPair struct {
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+}
+
+// userClaims returns the access token claims of the user, with its roles and scopes if it has any.
+func userClaims(user User) mjwt.Claims {
+	claims := mjwt.Claims{mjwt.IDKey: user.GetID()}
+	if u, ok := user.(RoleUser); ok && len(u.GetRoles()) > 0 {
+		claims[mjwt.RolesKey] = u.GetRoles()
+	}
+	if u, ok := user.(ScopeUser); ok && len(u.GetScopes()) > 0 {
+		claims[mjwt.ScopeKey] = strings.Join(u.GetScopes(), " ")
+	}
+	return claims
+}
+
+// writeTokens signs an access token for the user and writes it to the Authorization header or the cookie,
+// with refreshToken set, it also issues a refresh token and responds with a TokenPair.
+func writeTokens(c *Context, jwt mjwt.Engine, refreshToken *RefreshTokenConfig, user User, familyID string) {
+	tokenString, err := signClaims(jwt, userClaims(user))
+	if err != nil {
+		c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "sign error"))
+		return
+	}
+	inCookie := c.writeAccessToken(tokenString)
+	if refreshToken == nil {
+		c.JSON(200, "OK")
+		return
+	}
+	refreshTokenString, err := refreshToken.issue(user.GetID(), familyID)
+	if err != nil {
+		c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "issue refresh token error"))
+		return
+	}
+	pair := &TokenPair{
+		AccessToken:  tokenString,
+		RefreshToken: refreshTokenString,
+		TokenType:    "Bearer",
+	}
+	if inCookie {
+		pair.AccessToken = ""
+	}
+	c.JSON(200, pair)
+}
+
 func CreateAuthLoginHandler(jwt mjwt.Engine, userStore UserStore) HandlerFunc {
+	return CreateAuthLoginHandlerWithConfig(&CustomAuthConfig{
+		Jwt:       jwt,
+		UserStore: userStore,
+	})
+}
+
+func CreateAuthLoginHandlerWithConfig(config *CustomAuthConfig) HandlerFunc {
+	jwt := config.Jwt
+	userStore := config.UserStore
+	limit := config.LoginLimit
 	return func(c *Context) {
-		type Data struct {
-			Username string `json:"username"`
-			Password string `json:"password"`
-		}
-		var data Data
+		var data LoginRequest
 		if !c.MustBindJSON(&data) {
 			return
 		}
 
+		attempt, ok := c.mustAttemptLogin(limit, data.Username)
+		if !ok {
+			return
+		}
+
+		// the attempt has been counted as failed already
+		fail := func(code int, field string) {
+			if config.GenericLoginError {
+				c.AbortAndWriteError(0, CodeInvalidCredentials)
+				return
+			}
+			c.AbortAndWriteError(0, NewFieldError(code, field))
+		}
+
 		user, err := userStore.FindByUsername(data.Username)
 		if err != nil {
 			if err == ErrUsernameNotFound {
-				c.AbortAndWriteInvalidInputDetails(map[string]any{
-					"username": "username not exist",
-				})
+				if config.GenericLoginError {
+					_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(data.Password))
+				}
+				fail(CodeUsernameNotFound, "username")
 				return
 			}
 			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "find user by username error"))
@@ -34,75 +192,214 @@ func CreateAuthLoginHandler(jwt mjwt.Engine, userStore UserStore) HandlerFunc {
 
 		err = bcrypt.CompareHashAndPassword([]byte(user.GetPassword()), []byte(data.Password))
 		if err != nil {
-			c.AbortAndWriteInvalidInputDetails(map[string]any{
-				"password": "password not match",
-			})
+			fail(CodePasswordMismatch, "password")
 			return
 		}
-		tokenString, err := jwt.SignedStringForID(user.GetID())
+		// the failure of the username is kept until the second factor is verified
+		if config.TOTP != nil && writeMFAChallenge(c, config, user, attempt) {
+			return
+		}
+		if attempt != nil {
+			if err := attempt.succeed(); err != nil {
+				c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "reset login attempts error"))
+				return
+			}
+		}
+		writeTokens(c, jwt, config.RefreshToken, user, "")
+	}
+}
+
+// mustAttemptLogin counts a login of username, see LoginLimitConfig.attempt, and aborts the context if it has to wait.
+// The attempt is nil without limit.
+func (c *Context) mustAttemptLogin(limit *LoginLimitConfig, username string) (*loginAttempt, bool) {
+	if limit == nil {
+		return nil, true
+	}
+	attempt, retryAfter, locked, err := limit.attempt(username, c.ClientIP())
+	if err != nil {
+		c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "check login attempts error"))
+		return nil, false
+	}
+	if retryAfter > 0 {
+		c.abortAndWriteThrottled(retryAfter, locked)
+		return nil, false
+	}
+	return attempt, true
+}
+
+// abortAndWriteThrottled rejects a login attempt made before retryAfter has passed.
+func (c *Context) abortAndWriteThrottled(retryAfter time.Duration, locked bool) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	code := CodeLoginThrottled
+	if locked {
+		code = CodeAccountLocked
+	}
+	e := NewError(code, nil)
+	e.Params = map[string]any{"retry_after": seconds}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortAndWriteError(0, e)
+}
+
+var dummyPasswordHashOnce sync.Once
+var dummyPasswordHashValue []byte
+
+// dummyPasswordHash is compared with the password of unknown users, so that they take as long as the known ones.
+func dummyPasswordHash() []byte {
+	dummyPasswordHashOnce.Do(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte(randomToken(16)), bcrypt.DefaultCost)
 		if err != nil {
-			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "sign error"))
+			panic(err)
+		}
+		dummyPasswordHashValue = hash
+	})
+	return dummyPasswordHashValue
+}
+
+// CreateAuthRefreshTokenHandler exchanges a refresh token for a new TokenPair,
+// if a used refresh token is presented again, its whole family is revoked.
+// The user is loaded again so that the new access token carries its current roles and scopes.
+func CreateAuthRefreshTokenHandler(jwt mjwt.Engine, userStore UserStore, refreshToken *RefreshTokenConfig) HandlerFunc {
+	return func(c *Context) {
+		var data RefreshTokenRequest
+		if !c.MustBindJSON(&data) {
 			return
 		}
-		c.Header("Authorization", tokenString)
-		c.JSON(200, "OK")
+
+		hash := hashToken(data.RefreshToken)
+		token, err := refreshToken.Store.Find(hash)
+		if err != nil {
+			if err == ErrRefreshTokenNotFound {
+				c.AbortAndWriteError(0, CodeRefreshTokenInvalid)
+				return
+			}
+			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "find refresh token error"))
+			return
+		}
+		if token.Revoked || !token.ExpiresAt.After(refreshToken.now()) {
+			c.AbortAndWriteError(0, CodeRefreshTokenInvalid)
+			return
+		}
+
+		if err := refreshToken.Store.MarkUsed(hash); err != nil {
+			if err == ErrRefreshTokenUsed {
+				if err := refreshToken.Store.RevokeFamily(token.FamilyID); err != nil {
+					c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "revoke refresh token family error"))
+					return
+				}
+				c.AbortAndWriteError(0, CodeRefreshTokenReused)
+				return
+			}
+			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "mark refresh token used error"))
+			return
+		}
+
+		user, err := userStore.Find(token.UserID)
+		if err != nil {
+			if err == ErrUserIDNotFound {
+				c.AbortAndWriteError(0, CodeRefreshTokenInvalid)
+				return
+			}
+			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "find user error"))
+			return
+		}
+		writeTokens(c, jwt, refreshToken, user, token.FamilyID)
 	}
 }
 
+// CreateAuthRefreshHandler renews the caller's access token, keeping its roles and scopes.
 func CreateAuthRefreshHandler(jwt mjwt.Engine) HandlerFunc {
 	return func(c *Context) {
 		userID := c.MustIDContext()
 
-		tokenString, err := jwt.SignedStringForID(userID)
+		claims := mjwt.Claims{mjwt.IDKey: userID}
+		for _, key := range []string{mjwt.RolesKey, mjwt.ScopeKey} {
+			if v, ok := c.Claims()[key]; ok {
+				claims[key] = v
+			}
+		}
+		tokenString, err := signClaims(jwt, claims)
 		if err != nil {
 			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "sign error"))
 			return
 		}
-		c.Header("Authorization", tokenString)
+		c.writeAccessToken(tokenString)
 		c.JSON(200, "OK")
 	}
 }
 
-func CreateAuthUserHandler(userStore UserStore) HandlerFunc {
-	return func(c *Context) {
-		id := c.MustIDContext()
+// CreateAuthLogoutHandler revokes the caller's token, if revocation is enabled, and clears the cookie, with query all=true,
+// it revokes every token issued to the caller so far, as well as their refresh tokens.
+func CreateAuthLogoutHandler(jwt mjwt.Engine, refreshToken *RefreshTokenConfig) HandlerFunc {
+	return Typed(func(c *Context, req LogoutRequest) (string, error) {
+		// the cookie is cleared even if revoking fails, the client is signed out either way
+		c.clearAccessToken()
+		if req.All {
+			if err := revokeUserTokens(jwt, refreshToken, c.MustIDContext(), jwtNow(jwt)); err != nil {
+				return "", err
+			}
+		} else if err := revokeToken(jwt, c.Claims()); err != nil && !errors.Is(err, mjwt.ErrRevocationDisabled) {
+			return "", errors.Wrap(err, "revoke error")
+		}
+		return "OK", nil
+	})
+}
 
-		user, err := userStore.Find(id)
+// signClaims signs the claims if the engine is an mjwt.ClaimsEngine, other engines can only sign a lone id claim.
+func signClaims(jwt mjwt.Engine, claims mjwt.Claims) (string, error) {
+	if engine, ok := jwt.(mjwt.ClaimsEngine); ok {
+		return engine.SignedStringForClaims(claims)
+	}
+	if id, ok := claims[mjwt.IDKey].(uint); ok && len(claims) == 1 {
+		return jwt.SignedStringForID(id)
+	}
+	return "", ErrClaimsUnsupported
+}
+
+// revokeToken revokes the token of the claims, mjwt.ErrRevocationDisabled if the engine is not an mjwt.Revoker.
+func revokeToken(jwt mjwt.Engine, claims mjwt.Claims) error {
+	if revoker, ok := jwt.(mjwt.Revoker); ok {
+		return revoker.Revoke(claims)
+	}
+	return mjwt.ErrRevocationDisabled
+}
+
+// jwtNow returns the time of the engine's clock, which the iat of its tokens is computed from.
+func jwtNow(jwt mjwt.Engine) time.Time {
+	if clock, ok := jwt.(interface{ Now() time.Time }); ok {
+		return clock.Now()
+	}
+	return time.Now()
+}
+
+func CreateAuthUserHandler(userStore UserStore) HandlerFunc {
+	return Typed(func(c *Context, _ struct{}) (User, error) {
+		user, err := userStore.Find(c.MustIDContext())
 		if err != nil {
 			if err == ErrUserIDNotFound {
-				c.AbortAndWriteInvalidInputDetails(map[string]any{
-					"id": "user not found",
-				})
-				return
+				return nil, NewFieldError(CodeUserNotFound, "id")
 			}
-			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "find user error"))
-			return
+			return nil, errors.Wrap(err, "find user error")
 		}
-		c.JSON(200, user)
-	}
+		return user, nil
+	})
 }
 
 func CreateAuthSettingGetHandler(settingStore SettingStore, keysWhitelist []string) HandlerFunc {
-	return func(c *Context) {
-		key := c.Query("key")
-
+	return Typed(func(c *Context, req SettingGetRequest) (map[string]string, error) {
 		var keys []string
-		if key == "" {
-			for _, k := range strings.Split(c.Query("keys"), ",") {
+		if req.Key == "" {
+			for _, k := range strings.Split(req.Keys, ",") {
 				if strings.TrimSpace(k) != "" {
 					keys = append(keys, strings.TrimSpace(k))
 				}
 			}
 		} else {
-			keys = []string{key}
+			keys = []string{req.Key}
 		}
 
 		for _, k := range keys {
 			if len(keysWhitelist) > 0 && !mc.SliceContains(keysWhitelist, k) {
-				c.AbortAndWriteInvalidInputDetails(map[string]any{
-					"key": "key not allowed",
-				})
-				return
+				return nil, NewFieldError(CodeSettingKeyNotAllowed, "key")
 			}
 		}
 
@@ -112,42 +409,23 @@ func CreateAuthSettingGetHandler(settingStore SettingStore, keysWhitelist []stri
 		for _, k := range keys {
 			setting, err := settingStore.Get(id, k)
 			if err != nil {
-				c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "get setting error"))
-				return
+				return nil, errors.Wrap(err, "get setting error")
 			}
 			settings[k] = setting
 		}
-
-		c.JSON(200, settings)
-	}
+		return settings, nil
+	})
 }
 
 func CreateAuthSettingSetHandler(settingStore SettingStore, keysWhitelist []string) HandlerFunc {
-	return func(c *Context) {
-		key := c.Query("key")
-
-		type Data struct {
-			Value string `json:"value"`
-		}
-		var data Data
-		if !c.MustBindJSON(&data) {
-			return
+	return Typed(func(c *Context, req SettingSetRequest) (string, error) {
+		if len(keysWhitelist) > 0 && !mc.SliceContains(keysWhitelist, req.Key) {
+			return "", NewFieldError(CodeSettingKeyNotAllowed, "key")
 		}
 
-		if len(keysWhitelist) > 0 && !mc.SliceContains(keysWhitelist, key) {
-			c.AbortAndWriteInvalidInputDetails(map[string]any{
-				"key": "key not allowed",
-			})
-			return
+		if err := settingStore.Set(c.MustIDContext(), req.Key, req.Value); err != nil {
+			return "", errors.Wrap(err, "set setting error")
 		}
-
-		id := c.MustIDContext()
-
-		if err := settingStore.Set(id, key, data.Value); err != nil {
-			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "get setting error"))
-			return
-		}
-
-		c.JSON(200, "OK")
-	}
+		return "OK", nil
+	})
 }
@@ -0,0 +1,228 @@
+package mgin
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testUser struct {
+	ID       uint     `json:"id"`
+	Username string   `json:"username"`
+	Password string   `json:"-"`
+	Roles    []string `json:"roles"`
+}
+
+func (u *testUser) GetID() uint {
+	return u.ID
+}
+
+func (u *testUser) GetUsername() string {
+	return u.Username
+}
+
+func (u *testUser) GetPassword() string {
+	return u.Password
+}
+
+func (u *testUser) GetRoles() []string {
+	return u.Roles
+}
+
+type testUserStore struct {
+	users []*testUser
+}
+
+func newTestUserStore(t *testing.T) *testUserStore {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.Nil(t, err)
+	return &testUserStore{
+		users: []*testUser{{ID: 1, Username: "admin", Password: string(hash), Roles: []string{"admin"}}},
+	}
+}
+
+func (s *testUserStore) Find(userID uint) (User, error) {
+	for _, u := range s.users {
+		if u.ID == userID {
+			return u, nil
+		}
+	}
+	return nil, ErrUserIDNotFound
+}
+
+func (s *testUserStore) FindByUsername(username string) (User, error) {
+	for _, u := range s.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return nil, ErrUsernameNotFound
+}
+
+func serveJSON(r http.Handler, method, path string, body any, header http.Header) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[http.CanonicalHeaderKey(k)] = v
+	}
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAuthRefreshToken(t *testing.T) {
+	assertions := require.New(t)
+
+	store := NewMemoryRefreshTokenStore()
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:          mjwt.New([]byte("secret"), time.Minute),
+		UserStore:    newTestUserStore(t),
+		RefreshToken: &RefreshTokenConfig{Store: store},
+	}})
+
+	recorder := serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{
+		"username": "admin",
+		"password": "password",
+	}, nil)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	var login TokenPair
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &login))
+	assertions.NotEmpty(login.AccessToken)
+	assertions.NotEmpty(login.RefreshToken)
+	assertions.Equal(login.AccessToken, recorder.Header().Get("Authorization"))
+
+	// the access-token refresh route is replaced
+	recorder = serveJSON(r, http.MethodGet, AuthRefreshPath, nil, http.Header{
+		"Authorization": {"Bearer " + login.AccessToken},
+	})
+	assertions.Equal(http.StatusNotFound, recorder.Code)
+
+	recorder = serveJSON(r, http.MethodPost, AuthRefreshTokenPath, map[string]string{
+		"refresh_token": login.RefreshToken,
+	}, nil)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	var refreshed TokenPair
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &refreshed))
+	assertions.NotEqual(login.RefreshToken, refreshed.RefreshToken)
+
+	recorder = serveJSON(r, http.MethodGet, AuthUserPath, nil, http.Header{
+		"Authorization": {"Bearer " + refreshed.AccessToken},
+	})
+	assertions.Equal(http.StatusOK, recorder.Code)
+
+	// reusing a refresh token revokes the whole family
+	recorder = serveJSON(r, http.MethodPost, AuthRefreshTokenPath, map[string]string{
+		"refresh_token": login.RefreshToken,
+	}, nil)
+	assertions.Equal(http.StatusUnauthorized, recorder.Code)
+
+	recorder = serveJSON(r, http.MethodPost, AuthRefreshTokenPath, map[string]string{
+		"refresh_token": refreshed.RefreshToken,
+	}, nil)
+	assertions.Equal(http.StatusUnauthorized, recorder.Code)
+
+	recorder = serveJSON(r, http.MethodPost, AuthRefreshTokenPath, map[string]string{
+		"refresh_token": "unknown",
+	}, nil)
+	assertions.Equal(http.StatusUnauthorized, recorder.Code)
+}
+
+func TestAuthLogout(t *testing.T) {
+	assertions := require.New(t)
+
+	now := time.Now().Truncate(time.Second)
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	jwt.NowFunc = func() time.Time {
+		return now
+	}
+	jwt.Revocations = mjwt.NewMemoryRevocationStore()
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       jwt,
+		UserStore: newTestUserStore(t),
+	}})
+
+	login := func() http.Header {
+		recorder := serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{
+			"username": "admin",
+			"password": "password",
+		}, nil)
+		assertions.Equal(http.StatusOK, recorder.Code)
+		return http.Header{"Authorization": {"Bearer " + recorder.Header().Get("Authorization")}}
+	}
+
+	header1 := login()
+	header2 := login()
+
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodPost, AuthLogoutPath, nil, header1).Code)
+	assertions.Equal(http.StatusUnauthorized, serveJSON(r, http.MethodGet, AuthUserPath, nil, header1).Code)
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodGet, AuthUserPath, nil, header2).Code)
+
+	// all is read from the query only
+	now = now.Add(time.Second)
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodPost, AuthLogoutPath, map[string]bool{"All": true, "all": true}, login()).Code)
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodGet, AuthUserPath, nil, header2).Code)
+
+	header3 := login()
+	now = now.Add(time.Second)
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodPost, AuthLogoutPath+"?all=true", nil, header2).Code)
+	assertions.Equal(http.StatusUnauthorized, serveJSON(r, http.MethodGet, AuthUserPath, nil, header2).Code)
+	assertions.Equal(http.StatusUnauthorized, serveJSON(r, http.MethodGet, AuthUserPath, nil, header3).Code)
+
+	// signing in again right after, in the same second, works
+	now = now.Add(500 * time.Millisecond)
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodGet, AuthUserPath, nil, login()).Code)
+
+	// without revocation, logout succeeds and only clears the cookie
+	r = Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       mjwt.NewImpl([]byte("secret"), time.Hour),
+		UserStore: newTestUserStore(t),
+	}})
+	header := login()
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodPost, AuthLogoutPath, nil, header).Code)
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodPost, AuthLogoutPath+"?all=true", nil, header).Code)
+}
+
+// plainEngine hides the optional interfaces of the engine it wraps.
+type plainEngine struct {
+	mjwt.Engine
+}
+
+func TestAuth_PlainEngine(t *testing.T) {
+	assertions := require.New(t)
+
+	store := newTestUserStore(t)
+	store.users[0].Roles = nil
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       plainEngine{mjwt.NewImpl([]byte("secret"), time.Hour)},
+		UserStore: store,
+	}})
+
+	// engines which only implement mjwt.Engine sign in, refresh and sign out users without roles
+	recorder := serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{
+		"username": "admin",
+		"password": "password",
+	}, nil)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	header := http.Header{"Authorization": {"Bearer " + recorder.Header().Get("Authorization")}}
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodGet, AuthRefreshPath, nil, header).Code)
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodPost, AuthLogoutPath, nil, header).Code)
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodPost, AuthLogoutPath+"?all=true", nil, header).Code)
+
+	// roles can not be signed by them
+	store.users[0].Roles = []string{"admin"}
+	recorder = serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{
+		"username": "admin",
+		"password": "password",
+	}, nil)
+	assertions.Equal(http.StatusInternalServerError, recorder.Code)
+}
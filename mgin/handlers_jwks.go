@@ -0,0 +1,9 @@
+package mgin
+
+import "github.com/kacifer/mc/mjwt"
+
+func CreateJWKSHandler(provider mjwt.JWKSProvider) HandlerFunc {
+	return func(c *Context) {
+		c.JSON(200, provider.JWKS())
+	}
+}
@@ -0,0 +1,337 @@
+package mgin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kacifer/mc"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Keys of the built-in errors, they are written to E.Key.
+const (
+	KeyPageNotFound         = "page_not_found"
+	KeyMethodNotAllowed     = "method_not_allowed"
+	KeyServerError          = "server_error"
+	KeyUsernameNotFound     = "auth.username_not_found"
+	KeyPasswordMismatch     = "auth.password_mismatch"
+	KeyUserNotFound         = "auth.user_not_found"
+	KeyInvalidCredentials   = "auth.invalid_credentials"
+	KeyLoginThrottled       = "auth.login_throttled"
+	KeyAccountLocked        = "auth.account_locked"
+	KeyUsernameTaken        = "auth.username_taken"
+	KeyPasswordPolicy       = "auth.password_policy"
+	KeyPasswordTooShort     = "auth.password_too_short"
+	KeyPasswordTooLong      = "auth.password_too_long"
+	KeyPasswordNoUpper      = "auth.password_no_upper"
+	KeyPasswordNoLower      = "auth.password_no_lower"
+	KeyPasswordNoDigit      = "auth.password_no_digit"
+	KeyPasswordNoSymbol     = "auth.password_no_symbol"
+	KeyResetTokenInvalid    = "auth.reset_token_invalid"
+	KeyRefreshTokenInvalid  = "auth.refresh_token_invalid"
+	KeyRefreshTokenReused   = "auth.refresh_token_reused"
+	KeyMFARequired          = "auth.mfa_required"
+	KeyMFATokenInvalid      = "auth.mfa_token_invalid"
+	KeyMFACodeInvalid       = "auth.mfa_code_invalid"
+	KeyMFAAlreadyEnabled    = "auth.mfa_already_enabled"
+	KeyMFANotEnabled        = "auth.mfa_not_enabled"
+	KeyTokenInvalid         = "auth.token_invalid"
+	KeyTokenMissing         = "auth.token_missing"
+	KeyAuthHeaderMalformed  = "auth.header_malformed"
+	KeyTokenMalformed       = "auth.token_malformed"
+	KeyTokenSignature       = "auth.token_signature_invalid"
+	KeyTokenExpired         = "auth.token_expired"
+	KeyTokenNotValidYet     = "auth.token_not_valid_yet"
+	KeyTokenAudience        = "auth.token_audience_invalid"
+	KeyTokenRevoked         = "auth.token_revoked"
+	KeyInsufficientRoles    = "auth.insufficient_roles"
+	KeyInsufficientScopes   = "auth.insufficient_scopes"
+	KeySettingKeyNotAllowed = "setting.key_not_allowed"
+	KeyCrossOriginRequest   = "csrf.cross_origin"
+	KeyCSRFTokenInvalid     = "csrf.token_invalid"
+
+	// KeyValidationPrefix is followed by the failed validation tag, e.g. validation.required.
+	KeyValidationPrefix = "validation."
+)
+
+// LocaleKey is the context key of the *LocaleConfig set by CreateLocaleMiddleware.
+const LocaleKey = "locale"
+
+// LanguageKey is the context key of the language resolved by Context.Language.
+const LanguageKey = "language"
+
+const DefaultLanguageSettingKey = "language"
+
+// Catalog provides the messages of error keys, with {name} placeholders for the params.
+type Catalog interface {
+	// Message returns the message of key in lang, ok is false if there is none.
+	Message(lang string, key string) (message string, ok bool)
+}
+
+// MapCatalog maps languages to keys to messages.
+type MapCatalog map[string]map[string]string
+
+func (c MapCatalog) Message(lang string, key string) (string, bool) {
+	message, ok := c[lang][key]
+	return message, ok
+}
+
+// DefaultCatalog has the English and Chinese messages of the built-in errors.
+var DefaultCatalog = MapCatalog{
+	// the messages of the built-in error codes are added from their registration, see codes.go
+	"en": {
+		KeyPasswordTooShort: "password must be at least {min} characters",
+		KeyPasswordTooLong:  "password must be at most {max} bytes",
+		KeyPasswordNoUpper:  "password must contain an uppercase letter",
+		KeyPasswordNoLower:  "password must contain a lowercase letter",
+		KeyPasswordNoDigit:  "password must contain a digit",
+		KeyPasswordNoSymbol: "password must contain a symbol",
+	},
+	"zh": {
+		KeyPageNotFound:         "页面不存在",
+		KeyMethodNotAllowed:     "不支持的请求方法",
+		KeyServerError:          "服务器错误",
+		KeyUsernameNotFound:     "用户名不存在",
+		KeyPasswordMismatch:     "密码错误",
+		KeyUserNotFound:         "用户不存在",
+		KeyInvalidCredentials:   "用户名或密码错误",
+		KeyLoginThrottled:       "登录失败次数过多，请在 {retry_after} 秒后重试",
+		KeyAccountLocked:        "登录失败次数过多，账户已锁定 {retry_after} 秒",
+		KeyUsernameTaken:        "用户名已被占用",
+		KeyPasswordPolicy:       "密码不符合要求",
+		KeyPasswordTooShort:     "密码至少需要 {min} 个字符",
+		KeyPasswordTooLong:      "密码不能超过 {max} 字节",
+		KeyPasswordNoUpper:      "密码必须包含大写字母",
+		KeyPasswordNoLower:      "密码必须包含小写字母",
+		KeyPasswordNoDigit:      "密码必须包含数字",
+		KeyPasswordNoSymbol:     "密码必须包含符号",
+		KeyResetTokenInvalid:    "重置令牌无效或已过期",
+		KeyRefreshTokenInvalid:  "刷新令牌无效",
+		KeyRefreshTokenReused:   "刷新令牌已被使用",
+		KeyMFARequired:          "需要两步验证",
+		KeyMFATokenInvalid:      "两步验证令牌无效或已过期",
+		KeyMFACodeInvalid:       "验证码错误",
+		KeyMFAAlreadyEnabled:    "两步验证已启用",
+		KeyMFANotEnabled:        "两步验证未启用",
+		KeyTokenInvalid:         "令牌无效",
+		KeyTokenMissing:         "缺少认证信息",
+		KeyAuthHeaderMalformed:  "认证头格式错误",
+		KeyTokenMalformed:       "令牌格式错误",
+		KeyTokenSignature:       "令牌签名无效",
+		KeyTokenExpired:         "令牌已过期",
+		KeyTokenNotValidYet:     "令牌尚未生效",
+		KeyTokenAudience:        "令牌受众无效",
+		KeyTokenRevoked:         "令牌已被吊销",
+		KeyInsufficientRoles:    "缺少所需角色：{missing}",
+		KeyInsufficientScopes:   "缺少所需权限：{missing}",
+		KeySettingKeyNotAllowed: "不允许的设置项",
+		KeyCrossOriginRequest:   "不允许跨站请求",
+		KeyCSRFTokenInvalid:     "CSRF 令牌无效",
+
+		KeyValidationPrefix + "":         "{field}无效",
+		KeyValidationPrefix + "required": "{field}不能为空",
+		KeyValidationPrefix + "email":    "{field}必须是有效的邮箱地址",
+		KeyValidationPrefix + "url":      "{field}必须是有效的网址",
+		KeyValidationPrefix + "uuid":     "{field}必须是有效的 UUID",
+		KeyValidationPrefix + "numeric":  "{field}必须是数字",
+		KeyValidationPrefix + "alphanum": "{field}只能包含字母和数字",
+		KeyValidationPrefix + "len":      "{field}的长度必须是 {param}",
+		KeyValidationPrefix + "min":      "{field}不能小于 {param}",
+		KeyValidationPrefix + "max":      "{field}不能大于 {param}",
+		KeyValidationPrefix + "gt":       "{field}必须大于 {param}",
+		KeyValidationPrefix + "gte":      "{field}不能小于 {param}",
+		KeyValidationPrefix + "lt":       "{field}必须小于 {param}",
+		KeyValidationPrefix + "lte":      "{field}不能大于 {param}",
+		KeyValidationPrefix + "oneof":    "{field}必须是 {param} 之一",
+		KeyValidationPrefix + "eqfield":  "{field}必须与 {param} 相同",
+	},
+}
+
+// Msg is a localizable message, e.g. a value of E.Details, it is written as Default unless a catalog has Key.
+type Msg struct {
+	Key     string
+	Default string
+	Params  map[string]any
+}
+
+func (m Msg) String() string {
+	return renderMessage(m.Default, m.Params)
+}
+
+func (m Msg) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+func renderMessage(message string, params map[string]any) string {
+	if len(params) == 0 {
+		return message
+	}
+	replacements := make([]string, 0, len(params)*2)
+	for k, v := range params {
+		replacements = append(replacements, "{"+k+"}", fmt.Sprintf("%v", v))
+	}
+	return strings.NewReplacer(replacements...).Replace(message)
+}
+
+// LocaleConfig enables localized error messages, see CreateLocaleMiddleware.
+type LocaleConfig struct {
+	// Catalog is consulted before DefaultCatalog.
+	Catalog Catalog
+
+	// Languages are the supported languages, the first one is the default, defaults to en and zh.
+	Languages []string
+
+	// SettingStore, if set, provides the language of signed in users under SettingKey,
+	// it takes precedence over Accept-Language.
+	SettingStore SettingStore
+
+	// SettingKey defaults to DefaultLanguageSettingKey.
+	SettingKey string
+}
+
+func (l *LocaleConfig) languages() []string {
+	if len(l.Languages) == 0 {
+		return []string{"en", "zh"}
+	}
+	return l.Languages
+}
+
+// CreateLocaleMiddleware makes the errors written by the context localized, it should run before the auth middleware,
+// so that its errors are localized too. The language is resolved when a message is localized, see Context.Language.
+func CreateLocaleMiddleware(config *LocaleConfig) HandlerFunc {
+	return func(c *Context) {
+		c.Set(LocaleKey, config)
+		c.Next()
+	}
+}
+
+// resolvedLanguage is the language of a request for the signed in user, nil before the auth middleware.
+type resolvedLanguage struct {
+	UserID   any
+	Language string
+}
+
+// Language returns the language of the request, the setting of the signed in user if there is one,
+// otherwise the best match of Accept-Language, otherwise the default language.
+// It is empty if CreateLocaleMiddleware is not in use. It is resolved once per request and signed in user,
+// so that localizing many messages does not look the setting up each time.
+func (c *Context) Language() string {
+	config, ok := c.Value(LocaleKey).(*LocaleConfig)
+	if !ok {
+		return ""
+	}
+	userID, _ := c.Get(IDKey)
+	if resolved, ok := c.Value(LanguageKey).(*resolvedLanguage); ok && resolved.UserID == userID {
+		return resolved.Language
+	}
+	lang := c.resolveLanguage(config)
+	c.Set(LanguageKey, &resolvedLanguage{UserID: userID, Language: lang})
+	return lang
+}
+
+func (c *Context) resolveLanguage(config *LocaleConfig) string {
+	languages := config.languages()
+	if config.SettingStore != nil {
+		if userID, ok := c.Get(IDKey); ok && userID != nil {
+			if lang, err := config.SettingStore.Get(c.MustIDContext(), mc.VarOr(config.SettingKey, DefaultLanguageSettingKey)); err == nil {
+				if match := matchLanguage(lang, languages); match != "" {
+					return match
+				}
+			}
+		}
+	}
+	for _, lang := range parseAcceptHeader(c.GetHeader("Accept-Language")) {
+		if match := matchLanguage(lang, languages); match != "" {
+			return match
+		}
+	}
+	return languages[0]
+}
+
+// Localize returns the message of key in the language of the request, or renders defaultMessage.
+func (c *Context) Localize(key string, defaultMessage string, params map[string]any) string {
+	config, ok := c.Value(LocaleKey).(*LocaleConfig)
+	if ok && key != "" {
+		lang := c.Language()
+		if config.Catalog != nil {
+			if message, ok := config.Catalog.Message(lang, key); ok {
+				return renderMessage(message, params)
+			}
+		}
+		if message, ok := DefaultCatalog.Message(lang, key); ok {
+			return renderMessage(message, params)
+		}
+	}
+	return renderMessage(defaultMessage, params)
+}
+
+// localize returns a copy of e with its message and details in the language of the request.
+func (c *Context) localize(e *E) *E {
+	localized := *e
+	localized.Message = c.Localize(e.Key, e.Message, e.Params)
+	if e.Details != nil {
+		localized.Details = ErrorDetails{}
+		for k, v := range e.Details {
+			if m, ok := v.(Msg); ok {
+				v = c.Localize(m.Key, m.Default, m.Params)
+			}
+			localized.Details[k] = v
+		}
+	}
+	return &localized
+}
+
+// matchLanguage matches a language tag against the supported ones, exactly or by their primary language.
+func matchLanguage(lang string, supported []string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if lang == "" {
+		return ""
+	}
+	base, _, _ := strings.Cut(lang, "-")
+	for _, s := range supported {
+		if strings.ToLower(s) == lang {
+			return s
+		}
+	}
+	for _, s := range supported {
+		supportedBase, _, _ := strings.Cut(strings.ToLower(s), "-")
+		if supportedBase == base {
+			return s
+		}
+	}
+	return ""
+}
+
+// parseAcceptHeader returns the values of an Accept or Accept-Language header by descending quality,
+// without their parameters and without those of quality 0.
+func parseAcceptHeader(header string) []string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			langs = append(langs, weighted{lang, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+	result := make([]string, len(langs))
+	for i, l := range langs {
+		result[i] = l.lang
+	}
+	return result
+}
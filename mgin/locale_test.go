@@ -0,0 +1,135 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type testSettingStore map[uint]map[string]string
+
+func (s testSettingStore) Get(userID uint, key string) (string, error) {
+	return s[userID][key], nil
+}
+
+func (s testSettingStore) Set(userID uint, key string, value string) error {
+	if s[userID] == nil {
+		s[userID] = map[string]string{}
+	}
+	s[userID][key] = value
+	return nil
+}
+
+func TestLocale(t *testing.T) {
+	assertions := require.New(t)
+
+	settings := testSettingStore{}
+	r := Custom(CustomConfig{
+		Locale: &LocaleConfig{
+			Catalog:      MapCatalog{"zh": {KeyPageNotFound: "找不到页面"}},
+			SettingStore: settings,
+		},
+		Auth: &CustomAuthConfig{
+			Jwt:          mjwt.NewImpl([]byte("secret"), time.Hour),
+			UserStore:    newTestUserStore(t),
+			SettingStore: settings,
+		},
+	})
+
+	decode := func(header http.Header, method, path string, body any) E {
+		var e E
+		recorder := serveJSON(r, method, path, body, header)
+		assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+		return e
+	}
+
+	// the key is stable, the message follows Accept-Language
+	e := decode(http.Header{"Accept-Language": {"fr;q=0.9, zh-CN, en;q=0.8"}}, http.MethodGet, "/missing", nil)
+	assertions.Equal(KeyTokenMissing, e.Key)
+	assertions.Equal("缺少认证信息", e.Message)
+	e = decode(http.Header{"Accept-Language": {"en-US"}}, http.MethodGet, "/missing", nil)
+	assertions.Equal("auth header missing", e.Message)
+
+	e = decode(http.Header{"Accept-Language": {"zh"}}, http.MethodPost, AuthLoginPath, map[string]string{
+		"username": "nobody",
+	})
+	assertions.Equal(KeyUsernameNotFound, e.Key)
+	assertions.Equal("用户名不存在", e.Message)
+	assertions.Equal(ErrorDetails{"username": "用户名不存在"}, e.Details)
+
+	recorder := serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{
+		"username": "admin",
+		"password": "password",
+	}, nil)
+	signedIn := http.Header{"Authorization": {"Bearer " + recorder.Header().Get("Authorization")}}
+
+	// the user setting takes precedence over Accept-Language
+	assertions.Nil(settings.Set(1, DefaultLanguageSettingKey, "zh"))
+	signedIn.Set("Accept-Language", "en")
+	e = decode(signedIn, http.MethodGet, "/missing", nil)
+	assertions.Equal(KeyPageNotFound, e.Key)
+	assertions.Equal("找不到页面", e.Message)
+
+	assertions.Equal([]string{"zh-CN", "en", "fr"}, parseAcceptHeader("fr;q=0.5, zh-CN, en;q=0.8, *;q=0.1"))
+	assertions.Equal("zh-TW", matchLanguage("zh", []string{"en", "zh-TW"}))
+	assertions.Equal("", matchLanguage("fr", []string{"en", "zh"}))
+}
+
+func TestLocale_Validation(t *testing.T) {
+	assertions := require.New(t)
+
+	type Data struct {
+		Name string `json:"name" binding:"required"`
+	}
+	r := New()
+	r.Use(CreateLocaleMiddleware(&LocaleConfig{}))
+	r.POST("/", func(c *Context) {
+		var data Data
+		if c.MustBindJSON(&data) {
+			c.JSON(200, "OK")
+		}
+	})
+
+	var e E
+	recorder := serveJSON(r, http.MethodPost, "/", map[string]any{}, http.Header{"Accept-Language": {"zh"}})
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+	assertions.Equal(KeyValidationPrefix+"required", e.Key)
+	assertions.Equal("name不能为空", e.Message)
+	assertions.Equal(ErrorDetails{"name": "name不能为空"}, e.Details)
+}
+
+type countingSettingStore struct {
+	testSettingStore
+	gets int
+}
+
+func (s *countingSettingStore) Get(userID uint, key string) (string, error) {
+	s.gets++
+	return s.testSettingStore.Get(userID, key)
+}
+
+func TestLocale_LanguageResolvedOnce(t *testing.T) {
+	assertions := require.New(t)
+
+	settings := &countingSettingStore{testSettingStore: testSettingStore{1: {DefaultLanguageSettingKey: "zh"}}}
+	r := New()
+	r.Use(CreateLocaleMiddleware(&LocaleConfig{SettingStore: settings}))
+	r.GET("/", func(c *Context) {
+		// before the user is known, the language follows Accept-Language
+		assertions.Equal("en", c.Language())
+		c.Set(IDKey, uint(1))
+		for i := 0; i < 3; i++ {
+			assertions.Equal("用户名不存在", c.Localize(KeyUsernameNotFound, "", nil))
+		}
+		c.Set(IDKey, uint(2))
+		assertions.Equal("en", c.Language())
+		c.JSON(200, "OK")
+	})
+
+	recorder := serveJSON(r, http.MethodGet, "/", nil, http.Header{"Accept-Language": {"en"}})
+	assertions.Equal(http.StatusOK, recorder.Code)
+	assertions.Equal(2, settings.gets)
+}
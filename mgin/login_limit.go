@@ -0,0 +1,173 @@
+package mgin
+
+import (
+	"github.com/kacifer/mc"
+	"time"
+)
+
+const (
+	DefaultLoginBaseDelay       = time.Second
+	DefaultLoginLockoutDuration = 15 * time.Minute
+	DefaultLoginWindow          = time.Hour
+)
+
+// LoginLimit is how many failures are tolerated for a username or a client IP.
+type LoginLimit struct {
+	// FreeAttempts can fail without delay, then each failure doubles the delay before the next attempt.
+	FreeAttempts int
+
+	// MaxAttempts lock the key out for LoginLimitConfig.LockoutDuration.
+	MaxAttempts int
+}
+
+// LoginLimitConfig throttles failed logins per username and per client IP,
+// with an exponential backoff, and then a temporary lockout.
+type LoginLimitConfig struct {
+	Store LoginAttemptStore
+
+	// PerUsername defaults to 3 free attempts and a lockout after 10.
+	PerUsername LoginLimit
+
+	// PerIP defaults to 10 free attempts and a lockout after 100.
+	PerIP LoginLimit
+
+	// BaseDelay is the delay after the first failure beyond the free attempts, defaults to DefaultLoginBaseDelay.
+	BaseDelay time.Duration
+
+	// LockoutDuration defaults to DefaultLoginLockoutDuration, it also caps the backoff.
+	LockoutDuration time.Duration
+
+	// Window is how long failures are remembered since the last one, defaults to DefaultLoginWindow.
+	Window time.Duration
+
+	NowFunc func() time.Time
+}
+
+func (l *LoginLimitConfig) now() time.Time {
+	if l.NowFunc == nil {
+		return time.Now()
+	}
+	return l.NowFunc()
+}
+
+func (l *LoginLimitConfig) window() time.Duration {
+	return mc.VarOr(l.Window, DefaultLoginWindow)
+}
+
+func (l *LoginLimitConfig) limits(username string, ip string) map[string]LoginLimit {
+	return map[string]LoginLimit{
+		"username:" + username: {
+			FreeAttempts: mc.VarOr(l.PerUsername.FreeAttempts, 3),
+			MaxAttempts:  mc.VarOr(l.PerUsername.MaxAttempts, 10),
+		},
+		"ip:" + ip: {
+			FreeAttempts: mc.VarOr(l.PerIP.FreeAttempts, 10),
+			MaxAttempts:  mc.VarOr(l.PerIP.MaxAttempts, 100),
+		},
+	}
+}
+
+// blockedUntil returns when the next attempt is allowed after the failures, locked is true for a lockout.
+func (l *LoginLimitConfig) blockedUntil(attempts *LoginAttempts, limit LoginLimit) (until time.Time, locked bool) {
+	lockout := mc.VarOr(l.LockoutDuration, DefaultLoginLockoutDuration)
+	if attempts.Failures == 0 || l.now().Sub(attempts.LastFailure) >= l.window() {
+		return time.Time{}, false
+	}
+	if attempts.Failures >= limit.MaxAttempts {
+		return attempts.LastFailure.Add(lockout), true
+	}
+	if attempts.Failures <= limit.FreeAttempts {
+		return time.Time{}, false
+	}
+	shift := attempts.Failures - limit.FreeAttempts - 1
+	if shift > 30 {
+		shift = 30
+	}
+	delay := mc.VarOr(l.BaseDelay, DefaultLoginBaseDelay) << shift
+	if delay <= 0 || delay > lockout {
+		delay = lockout
+	}
+	return attempts.LastFailure.Add(delay), false
+}
+
+// check returns how long the login of username from ip has to wait, zero if it may proceed.
+func (l *LoginLimitConfig) check(username string, ip string) (retryAfter time.Duration, locked bool, err error) {
+	now := l.now()
+	for key, limit := range l.limits(username, ip) {
+		attempts, err := l.Store.Get(key)
+		if err != nil {
+			return 0, false, err
+		}
+		until, keyLocked := l.blockedUntil(attempts, limit)
+		if wait := until.Sub(now); wait > retryAfter {
+			retryAfter, locked = wait, keyLocked
+		}
+	}
+	return retryAfter, locked, nil
+}
+
+// loginAttempt is a login counted as failed by attempt before its credentials are checked, until it is released.
+type loginAttempt struct {
+	limit    *LoginLimitConfig
+	username string
+	ip       string
+	at       time.Time
+	// previous is the last failure of each key before the attempt
+	previous map[string]time.Time
+}
+
+// attempt returns how long the login of username from ip has to wait, zero if it may proceed.
+// A login which may proceed is counted as failed before the credentials are checked,
+// so that concurrent attempts can not all pass before their failures are recorded, succeed releases it.
+func (l *LoginLimitConfig) attempt(username string, ip string) (a *loginAttempt, retryAfter time.Duration, locked bool, err error) {
+	if retryAfter, locked, err = l.check(username, ip); err != nil || retryAfter > 0 {
+		return nil, retryAfter, locked, err
+	}
+	a = &loginAttempt{limit: l, username: username, ip: ip, at: l.now(), previous: map[string]time.Time{}}
+	var reserved []string
+	for key, limit := range l.limits(username, ip) {
+		previous, err := l.Store.Reserve(key, a.at, l.window())
+		if err != nil {
+			_ = a.release(reserved...)
+			return nil, 0, false, err
+		}
+		reserved = append(reserved, key)
+		a.previous[key] = previous.LastFailure
+		until, keyLocked := l.blockedUntil(previous, limit)
+		if wait := until.Sub(a.at); wait > retryAfter {
+			retryAfter, locked = wait, keyLocked
+		}
+	}
+	if retryAfter > 0 {
+		// a concurrent attempt got there first
+		if err := a.release(reserved...); err != nil {
+			return nil, 0, false, err
+		}
+		return nil, retryAfter, locked, nil
+	}
+	return a, 0, false, nil
+}
+
+func (a *loginAttempt) release(keys ...string) error {
+	for _, key := range keys {
+		if err := a.limit.Store.Release(key, a.at, a.previous[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// succeed forgets the failures of the username and releases the attempt of the IP, the failures of the IP are kept,
+// so that signing in to an account of one's own does not reset the count of an attacker.
+func (a *loginAttempt) succeed() error {
+	if err := a.limit.Store.Reset("username:" + a.username); err != nil {
+		return err
+	}
+	return a.release("ip:" + a.ip)
+}
+
+// passPassword releases the attempt of the IP once the password of a user with two-factor authentication is verified,
+// the failure of the username is kept until succeed, so that signing in again does not reset it.
+func (a *loginAttempt) passPassword() error {
+	return a.release("ip:" + a.ip)
+}
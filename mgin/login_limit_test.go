@@ -0,0 +1,179 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginLimit(t *testing.T) {
+	assertions := require.New(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       mjwt.NewImpl([]byte("secret"), time.Hour),
+		UserStore: newTestUserStore(t),
+		LoginLimit: &LoginLimitConfig{
+			Store:           NewMemoryLoginAttemptStore(),
+			PerUsername:     LoginLimit{FreeAttempts: 2, MaxAttempts: 4},
+			PerIP:           LoginLimit{FreeAttempts: 5, MaxAttempts: 6},
+			BaseDelay:       10 * time.Second,
+			LockoutDuration: time.Minute,
+			NowFunc: func() time.Time {
+				return now
+			},
+		},
+	}})
+	login := func(username string, password string, ip string) (int, E, string) {
+		recorder := serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{"username": username, "password": password}, http.Header{"X-Forwarded-For": {ip}})
+		var e E
+		if recorder.Code != http.StatusOK {
+			assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+		}
+		return recorder.Code, e, recorder.Header().Get("Retry-After")
+	}
+
+	// the free attempts fail without delay, then the delay doubles
+	for i := 0; i < 3; i++ {
+		code, _, _ := login("admin", "wrong", "192.0.2.1")
+		assertions.Equal(http.StatusUnprocessableEntity, code)
+	}
+	code, e, retryAfter := login("admin", "password", "192.0.2.1")
+	assertions.Equal(http.StatusTooManyRequests, code)
+	assertions.Equal(CodeLoginThrottled, e.Code)
+	assertions.Equal("too many failed logins, retry in 10 seconds", e.Message)
+	assertions.Equal("10", retryAfter)
+
+	// the limit is per username
+	code, _, _ = login("nobody", "wrong", "192.0.2.1")
+	assertions.Equal(http.StatusUnprocessableEntity, code)
+
+	// after MaxAttempts the username is locked out
+	now = now.Add(10 * time.Second)
+	code, _, _ = login("admin", "wrong", "192.0.2.1")
+	assertions.Equal(http.StatusUnprocessableEntity, code)
+	code, e, retryAfter = login("admin", "password", "192.0.2.1")
+	assertions.Equal(http.StatusTooManyRequests, code)
+	assertions.Equal(CodeAccountLocked, e.Code)
+	assertions.Equal("60", retryAfter)
+
+	// a successful login resets the username
+	now = now.Add(time.Minute)
+	code, _, _ = login("admin", "password", "192.0.2.2")
+	assertions.Equal(http.StatusOK, code)
+	code, _, _ = login("admin", "wrong", "192.0.2.2")
+	assertions.Equal(http.StatusUnprocessableEntity, code)
+
+	// but not the IP, which is locked out by its 6th failure
+	code, _, _ = login("someone", "wrong", "192.0.2.1")
+	assertions.Equal(http.StatusUnprocessableEntity, code)
+	code, e, _ = login("admin", "password", "192.0.2.1")
+	assertions.Equal(http.StatusTooManyRequests, code)
+	assertions.Equal(CodeAccountLocked, e.Code)
+
+	// failures are forgotten after the window
+	now = now.Add(DefaultLoginWindow)
+	code, _, _ = login("admin", "password", "192.0.2.1")
+	assertions.Equal(http.StatusOK, code)
+}
+
+func TestLoginLimit_Concurrent(t *testing.T) {
+	assertions := require.New(t)
+
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       mjwt.NewImpl([]byte("secret"), time.Hour),
+		UserStore: newTestUserStore(t),
+		LoginLimit: &LoginLimitConfig{
+			Store:       NewMemoryLoginAttemptStore(),
+			PerUsername: LoginLimit{FreeAttempts: 2, MaxAttempts: 10},
+		},
+	}})
+
+	// concurrent guesses can not all pass the check before their failures are recorded
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{"username": "admin", "password": "wrong"}, nil).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assertions.Equal(3, counts[http.StatusUnprocessableEntity])
+	assertions.Equal(7, counts[http.StatusTooManyRequests])
+}
+
+func TestGenericLoginError(t *testing.T) {
+	assertions := require.New(t)
+
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:               mjwt.NewImpl([]byte("secret"), time.Hour),
+		UserStore:         newTestUserStore(t),
+		GenericLoginError: true,
+	}})
+
+	// unknown usernames and wrong passwords are not told apart
+	for _, body := range []map[string]string{
+		{"username": "nobody", "password": "password"},
+		{"username": "admin", "password": "wrong"},
+	} {
+		recorder := serveJSON(r, http.MethodPost, AuthLoginPath, body, nil)
+		assertions.Equal(http.StatusUnprocessableEntity, recorder.Code)
+		var e E
+		assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+		assertions.Equal(E{Code: CodeInvalidCredentials, Key: KeyInvalidCredentials, Message: "invalid username or password"}, e)
+	}
+	assertions.NotEmpty(dummyPasswordHash())
+}
+
+func TestMemoryLoginAttemptStore(t *testing.T) {
+	assertions := require.New(t)
+
+	store := NewMemoryLoginAttemptStore()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err := store.Reserve("ip:192.0.2.1", now, time.Hour)
+	assertions.Nil(err)
+
+	// a released reservation restores the time of the previous failure
+	previous, err := store.Reserve("ip:192.0.2.1", now.Add(time.Minute), time.Hour)
+	assertions.Nil(err)
+	assertions.Equal(LoginAttempts{Failures: 1, LastFailure: now}, *previous)
+	assertions.Nil(store.Release("ip:192.0.2.1", now.Add(time.Minute), previous.LastFailure))
+	attempts, err := store.Get("ip:192.0.2.1")
+	assertions.Nil(err)
+	assertions.Equal(LoginAttempts{Failures: 1, LastFailure: now}, *attempts)
+
+	// unless a failure has been reserved since
+	_, err = store.Reserve("ip:192.0.2.1", now.Add(time.Minute), time.Hour)
+	assertions.Nil(err)
+	_, err = store.Reserve("ip:192.0.2.1", now.Add(2*time.Minute), time.Hour)
+	assertions.Nil(err)
+	assertions.Nil(store.Release("ip:192.0.2.1", now.Add(time.Minute), now))
+	attempts, err = store.Get("ip:192.0.2.1")
+	assertions.Nil(err)
+	assertions.Equal(LoginAttempts{Failures: 2, LastFailure: now.Add(2 * time.Minute)}, *attempts)
+
+	// the count starts over after the window, and stale keys are pruned along the way
+	now = now.Add(2 * time.Hour)
+	previous, err = store.Reserve("ip:192.0.2.1", now, time.Hour)
+	assertions.Nil(err)
+	assertions.Equal(2, previous.Failures)
+	attempts, err = store.Get("ip:192.0.2.1")
+	assertions.Nil(err)
+	assertions.Equal(1, attempts.Failures)
+	for i := 0; i < 3; i++ {
+		_, err = store.Reserve("username:admin", now.Add(2*time.Hour), time.Hour)
+		assertions.Nil(err)
+	}
+	assertions.Len(store.attempts, 1)
+}
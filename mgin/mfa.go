@@ -0,0 +1,408 @@
+package mgin
+
+import (
+	"encoding/base32"
+	"github.com/kacifer/mc"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/kacifer/mc/mtotp"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMFAPendingLease = 5 * time.Minute
+	DefaultMFAMaxAttempts  = 5
+)
+
+// MFAUserKey is the claim of the user ID in the tokens login returns to users with two-factor authentication.
+// Those tokens have the typ mjwt.TypeMFAPending and no id claim, so neither the auth middleware nor other services
+// verifying tokens of the same keys accept them, they can only be exchanged at the verify route.
+const MFAUserKey = "mfa_user"
+
+var ErrMFAPending = errors.New("two-factor authentication required")
+
+// ErrClaimsUnsupported is returned when the engine is not an mjwt.ClaimsEngine and has to sign more than an id.
+var ErrClaimsUnsupported = errors.New("jwt engine can not sign claims")
+
+// TOTPConfig enables TOTP two-factor authentication, CustomAuthConfig.UserStore must then be a TOTPStore.
+//
+// Login returns an MFAChallenge instead of the tokens to the users who have enabled it,
+// its token and a code of their authenticator app, or one of their recovery codes, are exchanged for the tokens.
+type TOTPConfig struct {
+	// Issuer is the issuer of the otpauth URIs, authenticator apps show it along with the username.
+	Issuer string
+
+	// PendingLease is how long the token of an MFAChallenge is valid, defaults to DefaultMFAPendingLease.
+	PendingLease time.Duration
+
+	// Skew is the number of periods accepted before and after the current one, defaults to 1.
+	Skew int
+
+	// RecoveryCodes is the number of recovery codes issued when TOTP is enabled, defaults to 10.
+	RecoveryCodes int
+
+	// MaxAttempts is how many codes a user can try within PendingLease, defaults to DefaultMFAMaxAttempts.
+	// The MFAChallenge token is revoked at the last failed one, and the user has to wait for PendingLease
+	// since the first, whether or not CustomAuthConfig.LoginLimit is set. The attempts are counted in memory.
+	MaxAttempts int
+
+	NowFunc func() time.Time
+
+	mu       sync.Mutex
+	attempts map[uint]mfaAttempts
+	calls    int
+}
+
+// mfaAttempts are the codes tried by a user since Since.
+type mfaAttempts struct {
+	Count int
+	Since time.Time
+}
+
+func (t *TOTPConfig) now() time.Time {
+	if t.NowFunc == nil {
+		return time.Now()
+	}
+	return t.NowFunc()
+}
+
+// reserveAttempt counts an attempt of the user before its code is checked, so that concurrent ones can not exceed
+// MaxAttempts, retryAfter is how long the user has to wait if it would, last is true for the last one allowed.
+func (t *TOTPConfig) reserveAttempt(userID uint) (retryAfter time.Duration, last bool) {
+	now := t.now()
+	lease := mc.VarOr(t.PendingLease, DefaultMFAPendingLease)
+	maxAttempts := mc.VarOr(t.MaxAttempts, DefaultMFAMaxAttempts)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.attempts == nil {
+		t.attempts = map[uint]mfaAttempts{}
+	}
+	// amortized pruning, the map is scanned once every as many calls as it has entries
+	if t.calls++; t.calls > len(t.attempts) {
+		t.calls = 0
+		for id, attempts := range t.attempts {
+			if now.Sub(attempts.Since) >= lease {
+				delete(t.attempts, id)
+			}
+		}
+	}
+	attempts := t.attempts[userID]
+	if attempts.Count == 0 || now.Sub(attempts.Since) >= lease {
+		attempts = mfaAttempts{Since: now}
+	}
+	if attempts.Count >= maxAttempts {
+		return attempts.Since.Add(lease).Sub(now), false
+	}
+	attempts.Count++
+	t.attempts[userID] = attempts
+	return 0, attempts.Count == maxAttempts
+}
+
+// resetAttempts forgets the attempts of the user once it has passed the second factor.
+func (t *TOTPConfig) resetAttempts(userID uint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.attempts, userID)
+}
+
+// MFAChallenge is the response of login for users with two-factor authentication.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	// Code is a code of the authenticator app, or a recovery code.
+	Code string `json:"code" binding:"required"`
+}
+
+// TOTPEnrollment is a new TOTP secret, it is enabled once a code of it is sent to the enable route.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPEnableRequest struct {
+	Secret string `json:"secret" binding:"required"`
+	Code   string `json:"code" binding:"required"`
+}
+
+type TOTPDisableRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
+
+func totpStore(config *CustomAuthConfig) TOTPStore {
+	store, ok := config.UserStore.(TOTPStore)
+	if !ok {
+		panic("mgin: CustomAuthConfig.TOTP requires the UserStore to be a TOTPStore")
+	}
+	return store
+}
+
+// writeMFAChallenge responds with an MFAChallenge if the user has enabled two-factor authentication,
+// challenged is false if it has not.
+// The login attempt, nil without CustomAuthConfig.LoginLimit, is then released for the IP only.
+func writeMFAChallenge(c *Context, config *CustomAuthConfig, user User, attempt *loginAttempt) (challenged bool) {
+	secret, err := totpStore(config).TOTPSecret(user.GetID())
+	if err != nil {
+		c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "get totp secret error"))
+		return true
+	}
+	if secret == "" {
+		return false
+	}
+	if attempt != nil {
+		if err := attempt.passPassword(); err != nil {
+			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "release login attempt error"))
+			return true
+		}
+	}
+	lease := mc.VarOr(config.TOTP.PendingLease, DefaultMFAPendingLease)
+	tokenString, err := signClaims(config.Jwt, mjwt.Claims{
+		mjwt.TypeKey: mjwt.TypeMFAPending,
+		MFAUserKey:   user.GetID(),
+		"exp":        config.TOTP.now().Add(lease).Unix(),
+	})
+	if err != nil {
+		c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "sign error"))
+		return true
+	}
+	c.JSON(http.StatusOK, &MFAChallenge{
+		MFARequired: true,
+		MFAToken:    tokenString,
+		ExpiresIn:   int(lease / time.Second),
+	})
+	return true
+}
+
+// verifySecondFactor reports whether code is a valid TOTP code of the user, or one of its recovery codes,
+// which is then used up.
+func verifySecondFactor(config *CustomAuthConfig, store TOTPStore, userID uint, code string) (bool, error) {
+	secret, err := store.TOTPSecret(userID)
+	if err != nil {
+		return false, errors.Wrap(err, "get totp secret error")
+	}
+	if secret == "" {
+		return false, nil
+	}
+	key, err := mtotp.ParseKey(secret)
+	if err != nil {
+		return false, errors.Wrap(err, "parse totp secret error")
+	}
+	code = strings.TrimSpace(code)
+	counter, ok, err := key.Validate(code, config.TOTP.now(), mc.VarOr(config.TOTP.Skew, 1))
+	if err != nil {
+		return false, errors.Wrap(err, "validate totp code error")
+	}
+	if ok {
+		return useTOTPCounter(store, userID, counter)
+	}
+	if err := store.UseRecoveryCode(userID, hashRecoveryCode(code)); err != nil {
+		if errors.Is(err, ErrRecoveryCodeNotFound) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "use recovery code error")
+	}
+	return true, nil
+}
+
+// useTOTPCounter reports whether the time step of a valid code has not been used yet, and marks it used.
+func useTOTPCounter(store TOTPStore, userID uint, counter uint64) (bool, error) {
+	if err := store.UseTOTPCounter(userID, counter); err != nil {
+		if errors.Is(err, ErrTOTPCodeUsed) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "use totp counter error")
+	}
+	return true, nil
+}
+
+// newRecoveryCodes generates recovery codes like 7f3kq-2m9xd, along with their hashes,
+// each of their 10 base32 characters carries 5 random bits.
+func newRecoveryCodes(n int) (codes []string, hashes []string) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < n; i++ {
+		code := strings.ToLower(encoding.EncodeToString(randomBytes(7)))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+// hashRecoveryCode hashes a recovery code regardless of its case and dashes.
+func hashRecoveryCode(code string) string {
+	return hashToken(strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code)))
+}
+
+// CreateAuthMFAVerifyHandler exchanges the token of an MFAChallenge and a code for the tokens login would return.
+// The MFAChallenge token is revoked if revocation is enabled, once used or once TOTPConfig.MaxAttempts is reached,
+// failures also count towards CustomAuthConfig.LoginLimit.
+func CreateAuthMFAVerifyHandler(config *CustomAuthConfig) HandlerFunc {
+	store := totpStore(config)
+	limit := config.LoginLimit
+	return func(c *Context) {
+		var data MFAVerifyRequest
+		if !c.MustBindRequest(&data) {
+			return
+		}
+
+		_, claims, err := config.Jwt.ValidateSignedString(data.MFAToken)
+		if typ, _ := claims[mjwt.TypeKey].(string); err != nil || typ != mjwt.TypeMFAPending {
+			c.AbortAndWriteError(0, CodeMFATokenInvalid)
+			return
+		}
+		var pendingClaims struct {
+			ID uint `json:"mfa_user"`
+		}
+		if err := claims.Decode(&pendingClaims); err != nil || pendingClaims.ID == 0 {
+			c.AbortAndWriteError(0, CodeMFATokenInvalid)
+			return
+		}
+		user, err := store.Find(pendingClaims.ID)
+		if err != nil {
+			c.AbortAndWriteHandlerError(errors.Wrap(err, "find user error"))
+			return
+		}
+
+		attempt, ok := c.mustAttemptLogin(limit, user.GetUsername())
+		if !ok {
+			return
+		}
+		retryAfter, last := config.TOTP.reserveAttempt(user.GetID())
+		if retryAfter > 0 {
+			c.abortAndWriteThrottled(retryAfter, true)
+			return
+		}
+		ok, err = verifySecondFactor(config, store, user.GetID(), data.Code)
+		if err != nil {
+			c.AbortAndWriteInternalError(http.StatusInternalServerError, err)
+			return
+		}
+		if !ok {
+			if last {
+				if err := revokeToken(config.Jwt, claims); err != nil && !errors.Is(err, mjwt.ErrRevocationDisabled) {
+					c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "revoke error"))
+					return
+				}
+			}
+			c.AbortAndWriteError(0, NewFieldError(CodeMFACodeInvalid, "code"))
+			return
+		}
+		config.TOTP.resetAttempts(user.GetID())
+
+		if attempt != nil {
+			if err := attempt.succeed(); err != nil {
+				c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "reset login attempts error"))
+				return
+			}
+		}
+		if err := revokeToken(config.Jwt, claims); err != nil && !errors.Is(err, mjwt.ErrRevocationDisabled) {
+			c.AbortAndWriteInternalError(http.StatusInternalServerError, errors.Wrap(err, "revoke error"))
+			return
+		}
+		writeTokens(c, config.Jwt, config.RefreshToken, user, "")
+	}
+}
+
+// CreateAuthTOTPEnrollHandler generates a TOTP secret for the signed in user, it is not enabled until confirmed.
+func CreateAuthTOTPEnrollHandler(config *CustomAuthConfig) HandlerFunc {
+	store := totpStore(config)
+	return Typed(func(c *Context, _ struct{}) (*TOTPEnrollment, error) {
+		user, err := store.Find(c.MustIDContext())
+		if err != nil {
+			return nil, errors.Wrap(err, "find user error")
+		}
+		secret, err := store.TOTPSecret(user.GetID())
+		if err != nil {
+			return nil, errors.Wrap(err, "get totp secret error")
+		}
+		if secret != "" {
+			return nil, NewError(CodeMFAAlreadyEnabled, nil)
+		}
+		key, err := mtotp.NewKey()
+		if err != nil {
+			return nil, err
+		}
+		return &TOTPEnrollment{
+			Secret: key.String(),
+			URI:    key.URI(config.TOTP.Issuer, user.GetUsername()),
+		}, nil
+	})
+}
+
+// CreateAuthTOTPEnableHandler enables an enrolled TOTP secret given a code of it, and returns new recovery codes.
+func CreateAuthTOTPEnableHandler(config *CustomAuthConfig) HandlerFunc {
+	store := totpStore(config)
+	return Typed(func(c *Context, req TOTPEnableRequest) (*RecoveryCodes, error) {
+		userID := c.MustIDContext()
+		secret, err := store.TOTPSecret(userID)
+		if err != nil {
+			return nil, errors.Wrap(err, "get totp secret error")
+		}
+		if secret != "" {
+			return nil, NewError(CodeMFAAlreadyEnabled, nil)
+		}
+		key, err := mtotp.ParseKey(req.Secret)
+		if err != nil {
+			return nil, NewFieldError(CodeMFACodeInvalid, "code")
+		}
+		counter, ok, err := key.Validate(strings.TrimSpace(req.Code), config.TOTP.now(), mc.VarOr(config.TOTP.Skew, 1))
+		if err != nil || !ok {
+			return nil, NewFieldError(CodeMFACodeInvalid, "code")
+		}
+		// the code can not be used again to sign in
+		ok, err = useTOTPCounter(store, userID, counter)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, NewFieldError(CodeMFACodeInvalid, "code")
+		}
+		codes, hashes := newRecoveryCodes(mc.VarOr(config.TOTP.RecoveryCodes, 10))
+		if err := store.SetTOTPSecret(userID, key.String()); err != nil {
+			return nil, errors.Wrap(err, "set totp secret error")
+		}
+		if err := store.SetRecoveryCodes(userID, hashes); err != nil {
+			return nil, errors.Wrap(err, "set recovery codes error")
+		}
+		return &RecoveryCodes{Codes: codes}, nil
+	})
+}
+
+// CreateAuthTOTPDisableHandler disables two-factor authentication of the signed in user given a code or a recovery code.
+func CreateAuthTOTPDisableHandler(config *CustomAuthConfig) HandlerFunc {
+	store := totpStore(config)
+	return Typed(func(c *Context, req TOTPDisableRequest) (string, error) {
+		userID := c.MustIDContext()
+		secret, err := store.TOTPSecret(userID)
+		if err != nil {
+			return "", errors.Wrap(err, "get totp secret error")
+		}
+		if secret == "" {
+			return "", NewError(CodeMFANotEnabled, nil)
+		}
+		ok, err := verifySecondFactor(config, store, userID, req.Code)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", NewFieldError(CodeMFACodeInvalid, "code")
+		}
+		if err := store.SetTOTPSecret(userID, ""); err != nil {
+			return "", errors.Wrap(err, "set totp secret error")
+		}
+		if err := store.SetRecoveryCodes(userID, nil); err != nil {
+			return "", errors.Wrap(err, "set recovery codes error")
+		}
+		return "OK", nil
+	})
+}
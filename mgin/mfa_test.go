@@ -0,0 +1,283 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/kacifer/mc/mtotp"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testTOTPStore struct {
+	*testUserStore
+	secrets       map[uint]string
+	recoveryCodes map[uint]map[string]bool
+	counters      map[uint]uint64
+}
+
+func (s *testTOTPStore) TOTPSecret(userID uint) (string, error) {
+	return s.secrets[userID], nil
+}
+
+func (s *testTOTPStore) SetTOTPSecret(userID uint, secret string) error {
+	s.secrets[userID] = secret
+	return nil
+}
+
+func (s *testTOTPStore) SetRecoveryCodes(userID uint, hashes []string) error {
+	s.recoveryCodes[userID] = map[string]bool{}
+	for _, hash := range hashes {
+		s.recoveryCodes[userID][hash] = true
+	}
+	return nil
+}
+
+func (s *testTOTPStore) UseRecoveryCode(userID uint, hash string) error {
+	if !s.recoveryCodes[userID][hash] {
+		return ErrRecoveryCodeNotFound
+	}
+	delete(s.recoveryCodes[userID], hash)
+	return nil
+}
+
+func (s *testTOTPStore) UseTOTPCounter(userID uint, counter uint64) error {
+	if counter <= s.counters[userID] {
+		return ErrTOTPCodeUsed
+	}
+	s.counters[userID] = counter
+	return nil
+}
+
+func TestTOTP(t *testing.T) {
+	assertions := require.New(t)
+
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time {
+		return now
+	}
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	jwt.NowFunc = clock
+	revocations := mjwt.NewMemoryRevocationStore()
+	revocations.NowFunc = clock
+	jwt.Revocations = revocations
+	store := &testTOTPStore{testUserStore: newTestUserStore(t), secrets: map[uint]string{}, recoveryCodes: map[uint]map[string]bool{}, counters: map[uint]uint64{}}
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       jwt,
+		UserStore: store,
+		TOTP:      &TOTPConfig{Issuer: "Example", NowFunc: clock},
+	}})
+	post := func(path string, body any, header http.Header, wantStatus int, wantCode int, out any) http.Header {
+		recorder := serveJSON(r, http.MethodPost, path, body, header)
+		assertions.Equal(wantStatus, recorder.Code, recorder.Body.String())
+		if wantCode != 0 {
+			var e E
+			assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+			assertions.Equal(wantCode, e.Code)
+		}
+		if out != nil {
+			assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), out))
+		}
+		return http.Header{"Authorization": {"Bearer " + recorder.Header().Get("Authorization")}}
+	}
+	login := func() *MFAChallenge {
+		var challenge MFAChallenge
+		post(AuthLoginPath, map[string]string{"username": "admin", "password": "password"}, nil, http.StatusOK, 0, &challenge)
+		return &challenge
+	}
+
+	// without two-factor authentication login returns the token
+	header := post(AuthLoginPath, map[string]string{"username": "admin", "password": "password"}, nil, http.StatusOK, 0, nil)
+	post(AuthTOTPDisablePath, map[string]string{"code": "123456"}, header, http.StatusConflict, CodeMFANotEnabled, nil)
+
+	// enrollment returns an otpauth URI, the secret is enabled by a code of it
+	var enrollment TOTPEnrollment
+	post(AuthTOTPEnrollPath, nil, header, http.StatusOK, 0, &enrollment)
+	uri, err := url.Parse(enrollment.URI)
+	assertions.Nil(err)
+	assertions.Equal("/Example:admin", uri.Path)
+	assertions.Equal(enrollment.Secret, uri.Query().Get("secret"))
+	key, err := mtotp.ParseKey(enrollment.Secret)
+	assertions.Nil(err)
+	code := func(t time.Time) string {
+		code, err := key.Code(t)
+		assertions.Nil(err)
+		return code
+	}
+	assertions.Empty(store.secrets[1])
+
+	post(AuthTOTPEnablePath, map[string]string{"secret": enrollment.Secret, "code": code(now.Add(-2 * mtotp.DefaultPeriod))}, header, http.StatusUnprocessableEntity, CodeMFACodeInvalid, nil)
+	var recovery RecoveryCodes
+	post(AuthTOTPEnablePath, map[string]string{"secret": enrollment.Secret, "code": code(now)}, header, http.StatusOK, 0, &recovery)
+	assertions.Len(recovery.Codes, 10)
+	assertions.Equal(enrollment.Secret, store.secrets[1])
+	post(AuthTOTPEnrollPath, nil, header, http.StatusConflict, CodeMFAAlreadyEnabled, nil)
+
+	// login now returns a pending token, which the auth middleware refuses
+	challenge := login()
+	assertions.True(challenge.MFARequired)
+	assertions.Equal(int(DefaultMFAPendingLease/time.Second), challenge.ExpiresIn)
+	pendingHeader := http.Header{"Authorization": {"Bearer " + challenge.MFAToken}}
+	recorder := serveJSON(r, http.MethodGet, AuthUserPath, nil, pendingHeader)
+	assertions.Equal(http.StatusUnauthorized, recorder.Code)
+	var e E
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+	assertions.Equal(CodeMFARequired, e.Code)
+	_, err = jwt.ExtractIDFromSignedString(challenge.MFAToken)
+	assertions.NotNil(err)
+
+	// a code of the previous period is accepted within the skew, the pending token only once,
+	// the code used to enable two-factor authentication is not accepted again
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": "wrong", "code": code(now)}, nil, http.StatusUnauthorized, CodeMFATokenInvalid, nil)
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": header.Get("Authorization")[len("Bearer "):], "code": code(now)}, nil, http.StatusUnauthorized, CodeMFATokenInvalid, nil)
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": "000000"}, nil, http.StatusUnprocessableEntity, CodeMFACodeInvalid, nil)
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": code(now)}, nil, http.StatusUnprocessableEntity, CodeMFACodeInvalid, nil)
+	now = now.Add(2 * mtotp.DefaultPeriod)
+	verified := post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": code(now.Add(-mtotp.DefaultPeriod))}, nil, http.StatusOK, 0, nil)
+	recorder = serveJSON(r, http.MethodGet, AuthUserPath, nil, verified)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": code(now)}, nil, http.StatusUnauthorized, CodeMFATokenInvalid, nil)
+
+	// a code can not be replayed within the skew
+	challenge = login()
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": code(now.Add(-mtotp.DefaultPeriod))}, nil, http.StatusUnprocessableEntity, CodeMFACodeInvalid, nil)
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": code(now)}, nil, http.StatusOK, 0, nil)
+
+	// pending tokens expire
+	challenge = login()
+	now = now.Add(DefaultMFAPendingLease)
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": code(now)}, nil, http.StatusUnauthorized, CodeMFATokenInvalid, nil)
+
+	// recovery codes can be used once, regardless of case
+	challenge = login()
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": recovery.Codes[0]}, nil, http.StatusOK, 0, nil)
+	challenge = login()
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": recovery.Codes[0]}, nil, http.StatusUnprocessableEntity, CodeMFACodeInvalid, nil)
+	verified = post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": " " + strings.ToUpper(recovery.Codes[1])}, nil, http.StatusOK, 0, nil)
+
+	// disabling requires a code
+	post(AuthTOTPDisablePath, map[string]string{"code": "000000"}, verified, http.StatusUnprocessableEntity, CodeMFACodeInvalid, nil)
+	post(AuthTOTPDisablePath, map[string]string{"code": code(now)}, verified, http.StatusOK, 0, nil)
+	assertions.Empty(store.secrets[1])
+	assertions.Empty(store.recoveryCodes[1])
+	header = post(AuthLoginPath, map[string]string{"username": "admin", "password": "password"}, nil, http.StatusOK, 0, nil)
+	assertions.NotEqual("Bearer ", header.Get("Authorization"))
+
+	assertions.Panics(func() {
+		Custom(CustomConfig{Auth: &CustomAuthConfig{
+			Jwt:       jwt,
+			UserStore: newTestUserStore(t),
+			TOTP:      &TOTPConfig{},
+		}})
+	})
+}
+
+func TestTOTP_LoginLimit(t *testing.T) {
+	assertions := require.New(t)
+
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time {
+		return now
+	}
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	jwt.NowFunc = clock
+	key, err := mtotp.NewKey()
+	assertions.Nil(err)
+	store := &testTOTPStore{testUserStore: newTestUserStore(t), secrets: map[uint]string{1: key.String()}, recoveryCodes: map[uint]map[string]bool{}, counters: map[uint]uint64{}}
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       jwt,
+		UserStore: store,
+		TOTP:      &TOTPConfig{NowFunc: clock},
+		LoginLimit: &LoginLimitConfig{
+			Store:       NewMemoryLoginAttemptStore(),
+			PerUsername: LoginLimit{FreeAttempts: 1, MaxAttempts: 10},
+			NowFunc:     clock,
+		},
+	}})
+	post := func(path string, body any, wantStatus int, out any) {
+		recorder := serveJSON(r, http.MethodPost, path, body, nil)
+		assertions.Equal(wantStatus, recorder.Code, recorder.Body.String())
+		if out != nil {
+			assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), out))
+		}
+	}
+	credentials := map[string]string{"username": "admin", "password": "password"}
+	code, err := key.Code(now)
+	assertions.Nil(err)
+
+	// the password alone does not reset the failures, signing in again does not grant more codes to guess
+	var challenge MFAChallenge
+	post(AuthLoginPath, credentials, http.StatusOK, &challenge)
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": "000000"}, http.StatusUnprocessableEntity, nil)
+	post(AuthLoginPath, credentials, http.StatusTooManyRequests, nil)
+
+	// the second factor does
+	now = now.Add(time.Second)
+	post(AuthLoginPath, credentials, http.StatusOK, &challenge)
+	now = now.Add(2 * time.Second)
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": code}, http.StatusOK, nil)
+	post(AuthLoginPath, credentials, http.StatusOK, &challenge)
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": challenge.MFAToken, "code": "000000"}, http.StatusUnprocessableEntity, nil)
+}
+
+func TestTOTP_MaxAttempts(t *testing.T) {
+	assertions := require.New(t)
+
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time {
+		return now
+	}
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	jwt.NowFunc = clock
+	revocations := mjwt.NewMemoryRevocationStore()
+	revocations.NowFunc = clock
+	jwt.Revocations = revocations
+	key, err := mtotp.NewKey()
+	assertions.Nil(err)
+	store := &testTOTPStore{testUserStore: newTestUserStore(t), secrets: map[uint]string{1: key.String()}, recoveryCodes: map[uint]map[string]bool{}, counters: map[uint]uint64{}}
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       jwt,
+		UserStore: store,
+		TOTP:      &TOTPConfig{MaxAttempts: 3, NowFunc: clock},
+	}})
+	post := func(path string, body any, wantStatus int, wantCode int, out any) {
+		recorder := serveJSON(r, http.MethodPost, path, body, nil)
+		assertions.Equal(wantStatus, recorder.Code, recorder.Body.String())
+		if wantCode != 0 {
+			var e E
+			assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+			assertions.Equal(wantCode, e.Code)
+		}
+		if out != nil {
+			assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), out))
+		}
+	}
+	login := func() string {
+		var challenge MFAChallenge
+		post(AuthLoginPath, map[string]string{"username": "admin", "password": "password"}, http.StatusOK, 0, &challenge)
+		return challenge.MFAToken
+	}
+	code := func() string {
+		code, err := key.Code(now)
+		assertions.Nil(err)
+		return code
+	}
+
+	// the pending token is revoked at the last attempt
+	token := login()
+	for i := 0; i < 3; i++ {
+		post(AuthMFAVerifyPath, map[string]string{"mfa_token": token, "code": "000000"}, http.StatusUnprocessableEntity, CodeMFACodeInvalid, nil)
+	}
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": token, "code": code()}, http.StatusUnauthorized, CodeMFATokenInvalid, nil)
+
+	// signing in again does not grant more attempts, without a login limit either
+	now = now.Add(time.Second)
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": login(), "code": code()}, http.StatusTooManyRequests, CodeAccountLocked, nil)
+
+	// until the lease has passed since the first attempt
+	now = now.Add(DefaultMFAPendingLease)
+	post(AuthMFAVerifyPath, map[string]string{"mfa_token": login(), "code": code()}, http.StatusOK, 0, nil)
+}
@@ -2,6 +2,7 @@ package mgin
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/kacifer/mc"
 	"github.com/kacifer/mc/mjwt"
 	"github.com/kacifer/mc/mlog"
 	"net/http"
@@ -14,6 +15,8 @@ type HandlerFunc func(*Context)
 // Create an instance of Engine, by using New() or Default()
 type Engine struct {
 	*gin.Engine
+
+	routes *routeRegistry
 }
 
 func (engine *Engine) NoRoute(handlers ...HandlerFunc) {
@@ -28,48 +31,67 @@ func (engine *Engine) Use(middleware ...HandlerFunc) {
 	engine.Engine.Use(AdaptHandlers(middleware)...)
 }
 
-func (engine *Engine) Group(relativePath string, handlers ...HandlerFunc) {
-	engine.Engine.Group(relativePath, AdaptHandlers(handlers)...)
+// UseAuth adds the auth middleware, the rules also decide the security of the routes in the OpenAPI document.
+func (engine *Engine) UseAuth(jwt mjwt.Engine, rules AuthRules) {
+	engine.registry().auth = &rules
+	engine.Use(CreateAuthMiddlewareWithRules(jwt, rules))
+}
+
+func (engine *Engine) root() *RouterGroup {
+	return &RouterGroup{
+		RouterGroup: &engine.Engine.RouterGroup,
+		engine:      engine,
+	}
+}
+
+// Group creates a RouterGroup, its routes share the path prefix and the handlers as middlewares.
+func (engine *Engine) Group(relativePath string, handlers ...HandlerFunc) *RouterGroup {
+	return engine.root().Group(relativePath, handlers...)
+}
+
+// Document sets the OpenAPI operation of the route, the route may be registered before or after.
+func (engine *Engine) Document(httpMethod, relativePath string, op Operation) {
+	engine.root().Document(httpMethod, relativePath, op)
 }
 
 func (engine *Engine) Handle(httpMethod, relativePath string, handlers ...HandlerFunc) {
-	engine.Engine.Handle(httpMethod, relativePath, AdaptHandlers(handlers)...)
+	engine.root().Handle(httpMethod, relativePath, handlers...)
 }
 
 func (engine *Engine) POST(relativePath string, handlers ...HandlerFunc) {
-	engine.Engine.POST(relativePath, AdaptHandlers(handlers)...)
+	engine.root().POST(relativePath, handlers...)
 }
 
 func (engine *Engine) GET(relativePath string, handlers ...HandlerFunc) {
-	engine.Engine.GET(relativePath, AdaptHandlers(handlers)...)
+	engine.root().GET(relativePath, handlers...)
 }
 
 func (engine *Engine) DELETE(relativePath string, handlers ...HandlerFunc) {
-	engine.Engine.DELETE(relativePath, AdaptHandlers(handlers)...)
+	engine.root().DELETE(relativePath, handlers...)
 }
 
 func (engine *Engine) PATCH(relativePath string, handlers ...HandlerFunc) {
-	engine.Engine.PATCH(relativePath, AdaptHandlers(handlers)...)
+	engine.root().PATCH(relativePath, handlers...)
 }
 
 func (engine *Engine) PUT(relativePath string, handlers ...HandlerFunc) {
-	engine.Engine.PUT(relativePath, AdaptHandlers(handlers)...)
+	engine.root().PUT(relativePath, handlers...)
 }
 
 func (engine *Engine) OPTIONS(relativePath string, handlers ...HandlerFunc) {
-	engine.Engine.OPTIONS(relativePath, AdaptHandlers(handlers)...)
+	engine.root().OPTIONS(relativePath, handlers...)
 }
 
 func (engine *Engine) HEAD(relativePath string, handlers ...HandlerFunc) {
-	engine.Engine.HEAD(relativePath, AdaptHandlers(handlers)...)
+	engine.root().HEAD(relativePath, handlers...)
 }
 
 func (engine *Engine) Any(relativePath string, handlers ...HandlerFunc) {
-	engine.Engine.Any(relativePath, AdaptHandlers(handlers)...)
+	engine.root().Any(relativePath, handlers...)
 }
 
 func (engine *Engine) Match(methods []string, relativePath string, handlers ...HandlerFunc) {
-	engine.Engine.Match(methods, relativePath, AdaptHandlers(handlers)...)
+	engine.root().Match(methods, relativePath, handlers...)
 }
 
 func (engine *Engine) Static(relativePath, root string) {
@@ -89,33 +111,139 @@ func (engine *Engine) StaticFileFS(relativePath, filepath string, fs http.FileSy
 }
 
 func New() *Engine {
-	return &Engine{gin.New()}
+	return &Engine{Engine: gin.New()}
 }
 
 func Default() *Engine {
-	return &Engine{gin.Default()}
+	return &Engine{Engine: gin.Default()}
 }
 
-var HealthCheckPaths = []string{"/healthz", "/api/v1/healthz"}
+// APIPath is the group the built-in routes of Custom are registered on.
+const APIPath = "/api/v1"
+
+// Paths of the built-in routes relative to their group, see RegisterAuthRoutes.
+const (
+	HealthCheckRoute = "/healthz"
+	AuthLoginRoute   = "/auth/login"
+	AuthRefreshRoute = "/auth/refresh"
+	AuthTokenRoute   = "/auth/token"
+	AuthUserRoute    = "/auth/user"
+	AuthLogoutRoute  = "/auth/logout"
+	SettingGetRoute  = "/settings"
+	SettingSetRoute  = "/settings"
+
+	AuthRegisterRoute             = "/auth/register"
+	AuthPasswordRoute             = "/auth/password"
+	AuthPasswordResetRoute        = "/auth/password/reset"
+	AuthPasswordResetConfirmRoute = "/auth/password/reset/confirm"
+
+	AuthMFAVerifyRoute   = "/auth/mfa/verify"
+	AuthTOTPEnrollRoute  = "/auth/totp/enroll"
+	AuthTOTPEnableRoute  = "/auth/totp/enable"
+	AuthTOTPDisableRoute = "/auth/totp/disable"
+)
 
-const AuthLoginPath = "/api/v1/auth/login"
-const AuthRefreshPath = "/api/v1/auth/refresh"
-const AuthUserPath = "/api/v1/auth/user"
-const SettingGetPath = "/api/v1/settings"
-const SettingSetPath = "/api/v1/settings"
+var HealthCheckPaths = []string{HealthCheckRoute, APIPath + HealthCheckRoute}
+
+const AuthLoginPath = APIPath + AuthLoginRoute
+const AuthRefreshPath = APIPath + AuthRefreshRoute
+const AuthRefreshTokenPath = APIPath + AuthTokenRoute
+const AuthUserPath = APIPath + AuthUserRoute
+const AuthLogoutPath = APIPath + AuthLogoutRoute
+const SettingGetPath = APIPath + SettingGetRoute
+const SettingSetPath = APIPath + SettingSetRoute
+const AuthRegisterPath = APIPath + AuthRegisterRoute
+const AuthPasswordPath = APIPath + AuthPasswordRoute
+const AuthPasswordResetPath = APIPath + AuthPasswordResetRoute
+const AuthPasswordResetConfirmPath = APIPath + AuthPasswordResetConfirmRoute
+const AuthMFAVerifyPath = APIPath + AuthMFAVerifyRoute
+const AuthTOTPEnrollPath = APIPath + AuthTOTPEnrollRoute
+const AuthTOTPEnablePath = APIPath + AuthTOTPEnableRoute
+const AuthTOTPDisablePath = APIPath + AuthTOTPDisableRoute
+const JWKSPath = "/.well-known/jwks.json"
 
 type CustomAuthConfig struct {
-	Jwt           mjwt.Engine
-	SkipAuthPaths []string
+	Jwt mjwt.Engine
+
+	// SkipAuthPaths and OptionalAuthPaths are the AuthRules of the auth middleware,
+	// routes can also be marked with Public or OptionalAuth when they are registered.
+	SkipAuthPaths     []string
+	OptionalAuthPaths []string
+
 	UserStore     UserStore
 	SettingStore  SettingStore
 	KeysWhitelist []string
+
+	// LoginLimit throttles failed logins, they are unlimited if nil.
+	LoginLimit *LoginLimitConfig
+
+	// GenericLoginError makes login fail with CodeInvalidCredentials whether the username or the password is wrong,
+	// and compare the password of unknown users with a dummy hash, so that neither tells whether a username exists.
+	GenericLoginError bool
+
+	// Account enables registration and the password routes, UserStore must then be an AccountStore.
+	Account *AccountConfig
+
+	// TOTP enables two-factor authentication, UserStore must then be a TOTPStore.
+	TOTP *TOTPConfig
+
+	// RefreshToken enables refresh tokens, login then returns a refresh token along with the access token,
+	// and AuthRefreshTokenPath replaces AuthRefreshPath, which would renew any valid access token forever.
+	RefreshToken *RefreshTokenConfig
+
+	// TokenSources are where the auth middleware looks up the token, in order,
+	// defaults to the Authorization header, followed by the cookie if Cookie is set.
+	TokenSources []TokenSource
+
+	// Cookie makes login and refresh set the access token in a cookie, and logout clear it.
+	Cookie *CookieConfig
+
+	// CSRF protects the requests authenticated by the cookie, defaults to a CSRFConfig matching Cookie if it is set.
+	CSRF *CSRFConfig
+}
+
+func (config *CustomAuthConfig) csrf() *CSRFConfig {
+	if config.CSRF != nil || config.Cookie == nil {
+		return config.CSRF
+	}
+	return &CSRFConfig{
+		Path:     config.Cookie.Path,
+		Domain:   config.Cookie.Domain,
+		Insecure: config.Cookie.Insecure,
+		SameSite: config.Cookie.SameSite,
+	}
+}
+
+func (config *CustomAuthConfig) tokenSources() []TokenSource {
+	if len(config.TokenSources) > 0 {
+		return config.TokenSources
+	}
+	if config.Cookie != nil {
+		return []TokenSource{BearerTokenSource, CookieTokenSource(config.Cookie.name())}
+	}
+	return nil
 }
 
 type CustomConfig struct {
 	Version string
 	Auth    *CustomAuthConfig
 
+	// BasePath is the group the built-in routes are registered on, defaults to APIPath.
+	BasePath string
+
+	// Routes overrides the path of each built-in route, or disables it.
+	// The built-in public routes are marked Public, so the auth middleware and the logger follow the final paths.
+	Routes Routes
+
+	// Locale localizes the error messages, in the language of Accept-Language or of a user setting.
+	Locale *LocaleConfig
+
+	// Problem writes errors as RFC 7807 problem documents to the clients which accept them.
+	Problem *ProblemConfig
+
+	// OpenAPI serves the OpenAPI document of the engine at OpenAPIConfig.Path, it is not served if nil.
+	OpenAPI *OpenAPIConfig
+
 	// Because we register some routes in Custom() function,
 	// so if you register middlewares after it returns, they will not be applied to those routes.
 	// You'll need to put them in ExtraMiddlewares to make them work.
@@ -125,24 +253,42 @@ type CustomConfig struct {
 func Custom(config CustomConfig) *Engine {
 	base := gin.New()
 
-	engine := &Engine{base}
+	engine := &Engine{Engine: base}
+
+	basePath := mc.VarOr(config.BasePath, APIPath)
+	routes := config.Routes
 
 	var middlewares []HandlerFunc
 	middlewares = append(middlewares, WrapHandler(gin.LoggerWithConfig(gin.LoggerConfig{
 		Output:    mlog.DefaultLogger.Out,
-		SkipPaths: HealthCheckPaths,
+		SkipPaths: routes.healthCheckPaths(basePath),
 	})))
 
 	middlewares = append(middlewares, WrapHandler(gin.Recovery()))
 
+	if config.Problem != nil {
+		middlewares = append(middlewares, CreateProblemMiddleware(config.Problem))
+	}
+
+	if config.Locale != nil {
+		middlewares = append(middlewares, CreateLocaleMiddleware(config.Locale))
+	}
+
 	if config.Auth != nil {
+		if config.Auth.Cookie != nil {
+			middlewares = append(middlewares, CreateCookieMiddleware(config.Auth.Cookie))
+		}
 		if config.Auth.Jwt != nil {
-			skipAuthPaths := config.Auth.SkipAuthPaths
-			for _, path := range HealthCheckPaths {
-				skipAuthPaths = append(skipAuthPaths, path)
+			rules := AuthRules{
+				SkipPaths:     config.Auth.SkipAuthPaths,
+				OptionalPaths: config.Auth.OptionalAuthPaths,
+				Sources:       config.Auth.tokenSources(),
 			}
-			skipAuthPaths = append(skipAuthPaths, AuthLoginPath)
-			middlewares = append(middlewares, CreateAuthMiddleware(config.Auth.Jwt, skipAuthPaths))
+			engine.registry().auth = &rules
+			middlewares = append(middlewares, CreateAuthMiddlewareWithRules(config.Auth.Jwt, rules))
+		}
+		if csrf := config.Auth.csrf(); csrf != nil {
+			middlewares = append(middlewares, CreateCSRFMiddleware(csrf))
 		}
 	}
 
@@ -151,31 +297,213 @@ func Custom(config CustomConfig) *Engine {
 	engine.Use(middlewares...)
 
 	healthzHandler := CreateHealthzHandler(config.Version)
-	for _, path := range HealthCheckPaths {
-		engine.Any(path, healthzHandler)
+	if config.OpenAPI != nil {
+		engine.GET(mc.VarOr(config.OpenAPI.Path, OpenAPIPath), Public, CreateOpenAPIHandler(engine, *config.OpenAPI))
 	}
 
-	if config.Auth != nil {
-		if config.Auth.Jwt != nil && config.Auth.UserStore != nil {
-			engine.POST(AuthLoginPath, CreateAuthLoginHandler(config.Auth.Jwt, config.Auth.UserStore))
-			engine.GET(AuthRefreshPath, CreateAuthRefreshHandler(config.Auth.Jwt))
-			engine.GET(AuthUserPath, CreateAuthUserHandler(config.Auth.UserStore))
-		}
+	if !routes.RootHealthCheck.Disabled {
+		engine.Any(routes.RootHealthCheck.path(HealthCheckRoute), Public, healthzHandler)
+	}
+
+	api := engine.Group(basePath)
+	if !routes.HealthCheck.Disabled {
+		api.Any(routes.HealthCheck.path(HealthCheckRoute), Public, healthzHandler)
+	}
 
-		if config.Auth.SettingStore != nil {
-			engine.GET(SettingGetPath, CreateAuthSettingGetHandler(config.Auth.SettingStore, config.Auth.KeysWhitelist))
-			engine.PUT(SettingSetPath, CreateAuthSettingSetHandler(config.Auth.SettingStore, config.Auth.KeysWhitelist))
+	if config.Auth != nil {
+		if !routes.JWKS.Disabled && jwksEnabled(config.Auth.Jwt) {
+			engine.GET(routes.JWKS.path(JWKSPath), Public, CreateJWKSHandler(config.Auth.Jwt.(mjwt.JWKSProvider)))
 		}
+		registerAuthRoutes(api, config.Auth, routes)
 	}
 
 	engine.HandleMethodNotAllowed = true
 
 	engine.NoRoute(func(c *Context) {
-		c.AbortAndWriteError(http.StatusNotFound, "page not found")
+		c.AbortAndWriteError(http.StatusNotFound, &E{
+			Code:    http.StatusNotFound,
+			Key:     KeyPageNotFound,
+			Message: "page not found",
+		})
 	})
 	engine.NoMethod(func(c *Context) {
-		c.AbortAndWriteError(http.StatusMethodNotAllowed, "method not allowed")
+		c.AbortAndWriteError(http.StatusMethodNotAllowed, &E{
+			Code:    http.StatusMethodNotAllowed,
+			Key:     KeyMethodNotAllowed,
+			Message: "method not allowed",
+		})
 	})
 
 	return engine
 }
+
+// RegisterAuthRoutes registers the auth and setting routes on the group,
+// Custom registers them on CustomConfig.BasePath, use it to mount them under another path.
+func RegisterAuthRoutes(group *RouterGroup, config *CustomAuthConfig) {
+	registerAuthRoutes(group, config, Routes{})
+}
+
+func registerAuthRoutes(group *RouterGroup, config *CustomAuthConfig, routes Routes) {
+	tags := []string{"auth"}
+	if config.Jwt != nil && config.UserStore != nil {
+		if !routes.Login.Disabled {
+			group.POST(routes.Login.path(AuthLoginRoute), Public, CreateAuthLoginHandlerWithConfig(config))
+			group.Document(http.MethodPost, routes.Login.path(AuthLoginRoute), Operation{
+				Summary:     "Sign in",
+				Description: "The access token is written to the Authorization header, a TokenPair is returned if refresh tokens are enabled, an MFAChallenge if the user has enabled two-factor authentication.",
+				Tags:        tags,
+				Request:     LoginRequest{},
+			})
+		}
+		if config.RefreshToken != nil {
+			if !routes.RefreshToken.Disabled {
+				group.POST(routes.RefreshToken.path(AuthTokenRoute), Public, CreateAuthRefreshTokenHandler(config.Jwt, config.UserStore, config.RefreshToken))
+				group.Document(http.MethodPost, routes.RefreshToken.path(AuthTokenRoute), Operation{
+					Summary:  "Exchange a refresh token for a new token pair",
+					Tags:     tags,
+					Request:  RefreshTokenRequest{},
+					Response: TokenPair{},
+				})
+			}
+		} else if !routes.Refresh.Disabled {
+			group.GET(routes.Refresh.path(AuthRefreshRoute), CreateAuthRefreshHandler(config.Jwt))
+			group.Document(http.MethodGet, routes.Refresh.path(AuthRefreshRoute), Operation{
+				Summary: "Renew the access token",
+				Tags:    tags,
+			})
+		}
+		if !routes.User.Disabled {
+			group.GET(routes.User.path(AuthUserRoute), CreateAuthUserHandler(config.UserStore))
+			group.Document(http.MethodGet, routes.User.path(AuthUserRoute), Operation{
+				Summary: "Get the signed in user",
+				Tags:    tags,
+			})
+		}
+		if !routes.Logout.Disabled {
+			group.POST(routes.Logout.path(AuthLogoutRoute), CreateAuthLogoutHandler(config.Jwt, config.RefreshToken))
+			group.Document(http.MethodPost, routes.Logout.path(AuthLogoutRoute), Operation{
+				Summary:  "Sign out",
+				Tags:     tags,
+				Request:  LogoutRequest{},
+				Response: "",
+			})
+		}
+		if config.Account != nil {
+			registerAccountRoutes(group, config, routes)
+		}
+		if config.TOTP != nil {
+			registerTOTPRoutes(group, config, routes)
+		}
+	}
+
+	if config.SettingStore != nil {
+		tags := []string{"settings"}
+		if !routes.SettingGet.Disabled {
+			group.GET(routes.SettingGet.path(SettingGetRoute), CreateAuthSettingGetHandler(config.SettingStore, config.KeysWhitelist))
+			group.Document(http.MethodGet, routes.SettingGet.path(SettingGetRoute), Operation{
+				Summary:  "Get settings of the signed in user",
+				Tags:     tags,
+				Request:  SettingGetRequest{},
+				Response: map[string]string{},
+			})
+		}
+		if !routes.SettingSet.Disabled {
+			group.PUT(routes.SettingSet.path(SettingSetRoute), CreateAuthSettingSetHandler(config.SettingStore, config.KeysWhitelist))
+			group.Document(http.MethodPut, routes.SettingSet.path(SettingSetRoute), Operation{
+				Summary:  "Set a setting of the signed in user",
+				Tags:     tags,
+				Request:  SettingSetRequest{},
+				Response: "",
+			})
+		}
+	}
+}
+
+func registerAccountRoutes(group *RouterGroup, config *CustomAuthConfig, routes Routes) {
+	tags := []string{"account"}
+	if config.Account.Registration && !routes.Register.Disabled {
+		group.POST(routes.Register.path(AuthRegisterRoute), Public, CreateAuthRegisterHandler(config))
+		group.Document(http.MethodPost, routes.Register.path(AuthRegisterRoute), Operation{
+			Summary:     "Sign up",
+			Description: "The user is signed in as by login.",
+			Tags:        tags,
+			Request:     RegisterRequest{},
+		})
+	}
+	if !routes.PasswordChange.Disabled {
+		group.PUT(routes.PasswordChange.path(AuthPasswordRoute), CreateAuthPasswordChangeHandler(config))
+		group.Document(http.MethodPut, routes.PasswordChange.path(AuthPasswordRoute), Operation{
+			Summary:     "Change the password of the signed in user",
+			Description: "The other tokens of the user are revoked, new ones are issued as by login.",
+			Tags:        tags,
+			Request:     PasswordChangeRequest{},
+		})
+	}
+	if config.Account.Notifier != nil {
+		if !routes.PasswordReset.Disabled {
+			group.POST(routes.PasswordReset.path(AuthPasswordResetRoute), Public, CreateAuthPasswordResetHandler(config))
+			group.Document(http.MethodPost, routes.PasswordReset.path(AuthPasswordResetRoute), Operation{
+				Summary:  "Request a password reset link",
+				Tags:     tags,
+				Request:  PasswordResetRequest{},
+				Response: "",
+			})
+		}
+		if !routes.PasswordResetConfirm.Disabled {
+			group.POST(routes.PasswordResetConfirm.path(AuthPasswordResetConfirmRoute), Public, CreateAuthPasswordResetConfirmHandler(config))
+			group.Document(http.MethodPost, routes.PasswordResetConfirm.path(AuthPasswordResetConfirmRoute), Operation{
+				Summary:  "Set a new password with a reset token",
+				Tags:     tags,
+				Request:  PasswordResetConfirmRequest{},
+				Response: "",
+			})
+		}
+	}
+}
+
+func registerTOTPRoutes(group *RouterGroup, config *CustomAuthConfig, routes Routes) {
+	tags := []string{"mfa"}
+	if !routes.MFAVerify.Disabled {
+		group.POST(routes.MFAVerify.path(AuthMFAVerifyRoute), Public, CreateAuthMFAVerifyHandler(config))
+		group.Document(http.MethodPost, routes.MFAVerify.path(AuthMFAVerifyRoute), Operation{
+			Summary:     "Complete a sign in with a two-factor authentication code",
+			Description: "The mfa_token of the MFAChallenge returned by login is exchanged for the tokens login would return.",
+			Tags:        tags,
+			Request:     MFAVerifyRequest{},
+		})
+	}
+	if !routes.TOTPEnroll.Disabled {
+		group.POST(routes.TOTPEnroll.path(AuthTOTPEnrollRoute), CreateAuthTOTPEnrollHandler(config))
+		group.Document(http.MethodPost, routes.TOTPEnroll.path(AuthTOTPEnrollRoute), Operation{
+			Summary:     "Generate a TOTP secret for the signed in user",
+			Description: "The secret is enabled once a code of it is sent to the enable route.",
+			Tags:        tags,
+			Response:    TOTPEnrollment{},
+		})
+	}
+	if !routes.TOTPEnable.Disabled {
+		group.POST(routes.TOTPEnable.path(AuthTOTPEnableRoute), CreateAuthTOTPEnableHandler(config))
+		group.Document(http.MethodPost, routes.TOTPEnable.path(AuthTOTPEnableRoute), Operation{
+			Summary:     "Enable two-factor authentication of the signed in user",
+			Description: "The recovery codes are returned only once, each of them can be used once instead of a code.",
+			Tags:        tags,
+			Request:     TOTPEnableRequest{},
+			Response:    RecoveryCodes{},
+		})
+	}
+	if !routes.TOTPDisable.Disabled {
+		group.POST(routes.TOTPDisable.path(AuthTOTPDisableRoute), CreateAuthTOTPDisableHandler(config))
+		group.Document(http.MethodPost, routes.TOTPDisable.path(AuthTOTPDisableRoute), Operation{
+			Summary:  "Disable two-factor authentication of the signed in user",
+			Tags:     tags,
+			Request:  TOTPDisableRequest{},
+			Response: "",
+		})
+	}
+}
+
+// jwksEnabled reports whether the engine has asymmetric keys to publish.
+func jwksEnabled(jwt mjwt.Engine) bool {
+	provider, ok := jwt.(mjwt.JWKSProvider)
+	return ok && len(provider.JWKS().Keys) > 0
+}
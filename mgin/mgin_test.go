@@ -1,8 +1,16 @@
 package mgin
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/kacifer/mc/mjwt"
 	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestMgin(t *testing.T) {
@@ -19,3 +27,319 @@ func TestMgin(t *testing.T) {
 
 	assertions.NotNil(r.Routes())
 }
+
+func TestCustom_JWKS(t *testing.T) {
+	assertions := require.New(t)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assertions.Nil(err)
+	jwt, err := mjwt.NewImplWithPrivateKey(key, time.Hour)
+	assertions.Nil(err)
+
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{Jwt: jwt}})
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+	assertions.Equal(http.StatusOK, recorder.Code)
+
+	var jwks mjwt.JSONWebKeySet
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &jwks))
+	assertions.Len(jwks.Keys, 1)
+	assertions.Equal("EdDSA", jwks.Keys[0].Alg)
+
+	// HMAC engines have nothing to publish
+	r = Custom(CustomConfig{Auth: &CustomAuthConfig{Jwt: mjwt.NewDefault([]byte("secret"))}})
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+	assertions.Equal(http.StatusUnauthorized, recorder.Code)
+}
+
+func TestCreateAuthMiddleware(t *testing.T) {
+	assertions := require.New(t)
+
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	jwt.Revocations = mjwt.NewMemoryRevocationStore()
+	signed, err := jwt.SignedStringForID(1)
+	assertions.Nil(err)
+	forged, err := mjwt.NewImpl([]byte("forged"), time.Hour).SignedStringForID(1)
+	assertions.Nil(err)
+	expiredJwt := mjwt.NewImpl([]byte("secret"), -time.Hour)
+	expired, err := expiredJwt.SignedStringForID(1)
+	assertions.Nil(err)
+	revoked, err := jwt.SignedStringForID(1)
+	assertions.Nil(err)
+	_, claims, err := jwt.ValidateSignedString(revoked)
+	assertions.Nil(err)
+	assertions.Nil(jwt.Revoke(claims))
+
+	r := New()
+	r.Use(CreateAuthMiddleware(jwt, nil))
+	r.GET("/test", func(c *Context) {
+		c.JSON(200, c.MustIDContext())
+	})
+
+	for _, tt := range []struct {
+		header          string
+		status          int
+		code            int
+		wwwAuthenticate string
+	}{
+		{"", http.StatusUnauthorized, CodeTokenMissing, "Bearer"},
+		{"Bear", http.StatusBadRequest, CodeAuthHeaderMalformed, `Bearer error="invalid_request"`},
+		{"Bearer abc", http.StatusUnauthorized, CodeTokenMalformed, `Bearer error="invalid_token"`},
+		{"Bearer " + forged, http.StatusUnauthorized, CodeTokenSignatureInvalid, `Bearer error="invalid_token"`},
+		{"Bearer " + expired, http.StatusUnauthorized, CodeTokenExpired, `Bearer error="invalid_token"`},
+		{"Bearer " + revoked, http.StatusUnauthorized, CodeTokenRevoked, `Bearer error="invalid_token"`},
+		{"Bearer " + signed, http.StatusOK, 0, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("Authorization", tt.header)
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		assertions.Equal(tt.status, recorder.Code, tt.header)
+		assertions.True(strings.HasPrefix(recorder.Header().Get("WWW-Authenticate"), tt.wwwAuthenticate), tt.header)
+		if tt.code != 0 {
+			var e E
+			assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+			assertions.Equal(tt.code, e.Code, tt.header)
+		}
+	}
+}
+
+func TestRequireRoles(t *testing.T) {
+	assertions := require.New(t)
+
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	signed, err := jwt.SignedStringForClaims(mjwt.Claims{
+		mjwt.IDKey:    1,
+		mjwt.RolesKey: []string{"editor"},
+		mjwt.ScopeKey: "posts:read posts:write",
+	})
+	assertions.Nil(err)
+
+	r := New()
+	r.Use(CreateAuthMiddleware(jwt, []string{"/public"}))
+	ok := func(c *Context) {
+		c.JSON(200, c.Claims().Roles())
+	}
+	r.GET("/editor", RequireRoles("editor"), ok)
+	r.GET("/admin", RequireRoles("admin", "editor"), ok)
+	r.GET("/any", RequireAnyRole("admin", "editor"), ok)
+	r.GET("/read", RequireScopes("posts:read"), ok)
+	r.GET("/delete", RequireScopes("posts:read", "posts:delete"), ok)
+	r.GET("/public", RequireRoles("editor"), ok)
+
+	header := http.Header{"Authorization": {"Bearer " + signed}}
+	for _, tt := range []struct {
+		path    string
+		status  int
+		code    int
+		details ErrorDetails
+	}{
+		{"/editor", http.StatusOK, 0, nil},
+		{"/admin", http.StatusForbidden, CodeInsufficientRole, ErrorDetails{"roles": []any{"admin"}}},
+		{"/any", http.StatusOK, 0, nil},
+		{"/read", http.StatusOK, 0, nil},
+		{"/delete", http.StatusForbidden, CodeInsufficientScope, ErrorDetails{"scopes": []any{"posts:delete"}}},
+	} {
+		recorder := serveJSON(r, http.MethodGet, tt.path, nil, header)
+		assertions.Equal(tt.status, recorder.Code, tt.path)
+		if tt.code != 0 {
+			var e E
+			assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+			assertions.Equal(tt.code, e.Code, tt.path)
+			assertions.Equal(tt.details, e.Details, tt.path)
+		}
+	}
+
+	recorder := serveJSON(r, http.MethodGet, "/delete", nil, header)
+	assertions.Equal(`Bearer error="insufficient_scope", scope="posts:read posts:delete"`, recorder.Header().Get("WWW-Authenticate"))
+
+	// without authentication there is nothing to authorize
+	recorder = serveJSON(r, http.MethodGet, "/public", nil, nil)
+	assertions.Equal(http.StatusUnauthorized, recorder.Code)
+}
+
+func TestContext_Claims(t *testing.T) {
+	assertions := require.New(t)
+
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	r := Custom(CustomConfig{Auth: &CustomAuthConfig{
+		Jwt:       jwt,
+		UserStore: newTestUserStore(t),
+	}})
+	r.GET("/claims", func(c *Context) {
+		var claims struct {
+			ID    uint     `json:"id"`
+			Roles []string `json:"roles"`
+		}
+		assertions.Nil(c.BindClaims(&claims))
+		assertions.True(c.HasRole("admin"))
+		assertions.False(c.HasScope("posts:read"))
+		c.JSON(200, claims)
+	})
+
+	recorder := serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{
+		"username": "admin",
+		"password": "password",
+	}, nil)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	header := http.Header{"Authorization": {"Bearer " + recorder.Header().Get("Authorization")}}
+
+	// roles survive the refresh
+	recorder = serveJSON(r, http.MethodGet, AuthRefreshPath, nil, header)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	header = http.Header{"Authorization": {"Bearer " + recorder.Header().Get("Authorization")}}
+
+	recorder = serveJSON(r, http.MethodGet, "/claims", nil, header)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	assertions.JSONEq(`{"id":1,"roles":["admin"]}`, recorder.Body.String())
+}
+
+func TestCreateAuthMiddlewareWithRules(t *testing.T) {
+	assertions := require.New(t)
+
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	signed, err := jwt.SignedStringForID(7)
+	assertions.Nil(err)
+
+	r := New()
+	r.Use(CreateAuthMiddlewareWithRules(jwt, AuthRules{
+		SkipPaths:     []string{"/public/*", "/files/*.png", "GET /share/:token"},
+		OptionalPaths: []string{"/feed"},
+	}))
+	handler := func(c *Context) {
+		if id, ok := c.IDContext(); ok {
+			c.JSON(200, id)
+			return
+		}
+		c.JSON(200, nil)
+	}
+	r.GET("/public/docs/intro", handler)
+	r.GET("/files/:name", handler)
+	r.GET("/docs/*path", handler)
+	r.GET("/share/:token", handler)
+	r.POST("/share/:token", handler)
+	r.GET("/feed", handler)
+	r.GET("/open/:id", Public, handler)
+	r.GET("/home", OptionalAuth, handler)
+	r.GET("/private", handler)
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		header string
+		status int
+		body   string
+	}{
+		{http.MethodGet, "/public/docs/intro", "", http.StatusOK, "null"},
+		{http.MethodGet, "/public/../private", "", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/docs/../public/x", "", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/files/a.png", "", http.StatusOK, "null"},
+		{http.MethodGet, "/files/a.txt", "", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/share/abc", "", http.StatusOK, "null"},
+		{http.MethodPost, "/share/abc", "", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/open/1", "", http.StatusOK, "null"},
+		{http.MethodGet, "/private", "", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/private", "Bearer " + signed, http.StatusOK, "7"},
+		// optional auth sets the user ID when a valid token is presented, and rejects invalid ones
+		{http.MethodGet, "/feed", "", http.StatusOK, "null"},
+		{http.MethodGet, "/feed", "Bearer " + signed, http.StatusOK, "7"},
+		{http.MethodGet, "/home", "", http.StatusOK, "null"},
+		{http.MethodGet, "/home", "Bearer " + signed, http.StatusOK, "7"},
+		{http.MethodGet, "/home", "Bearer abc", http.StatusUnauthorized, ""},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", tt.header)
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		assertions.Equal(tt.status, recorder.Code, tt.method+" "+tt.path)
+		if tt.body != "" {
+			assertions.Equal(tt.body, recorder.Body.String(), tt.method+" "+tt.path)
+		}
+	}
+}
+
+func TestRouterGroup(t *testing.T) {
+	assertions := require.New(t)
+
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	signed, err := jwt.SignedStringForClaims(mjwt.Claims{mjwt.IDKey: 1, mjwt.RolesKey: []string{"editor"}})
+	assertions.Nil(err)
+	header := http.Header{"Authorization": {"Bearer " + signed}}
+
+	r := New()
+	r.Use(CreateAuthMiddleware(jwt, nil))
+
+	var trace []string
+	api := r.Group("/api", func(c *Context) {
+		trace = append(trace, "api")
+	})
+	api.GET("/ping", func(c *Context) {
+		c.JSON(200, "pong")
+	})
+	admin := api.Group("/admin").Use(RequireRoles("admin"))
+	admin.GET("/users", func(c *Context) {
+		c.JSON(200, "users")
+	})
+	posts := api.Group("/posts", RequireAnyRole("editor"))
+	posts.GET("/:id", func(c *Context) {
+		trace = append(trace, "post")
+		c.JSON(200, c.IDParam())
+	})
+	public := api.Group("/public", Public)
+	public.GET("/info", func(c *Context) {
+		c.JSON(200, "info")
+	})
+
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodGet, "/api/ping", nil, header).Code)
+	assertions.Equal(http.StatusForbidden, serveJSON(r, http.MethodGet, "/api/admin/users", nil, header).Code)
+	recorder := serveJSON(r, http.MethodGet, "/api/posts/3", nil, header)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	assertions.Equal("3", recorder.Body.String())
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodGet, "/api/public/info", nil, nil).Code)
+	assertions.Equal([]string{"api", "api", "api", "post", "api"}, trace)
+
+	// the built-in routes can be mounted under another path
+	r = New()
+	r.Use(CreateAuthMiddleware(jwt, nil))
+	RegisterAuthRoutes(r.Group("/v2"), &CustomAuthConfig{Jwt: jwt, UserStore: newTestUserStore(t)})
+	recorder = serveJSON(r, http.MethodPost, "/v2"+AuthLoginRoute, map[string]string{
+		"username": "admin",
+		"password": "password",
+	}, nil)
+	assertions.Equal(http.StatusOK, recorder.Code)
+}
+
+func TestCustom_Routes(t *testing.T) {
+	assertions := require.New(t)
+
+	r := Custom(CustomConfig{
+		BasePath: "/svc-a/api/v2",
+		Routes: Routes{
+			RootHealthCheck: Route{Disabled: true},
+			Login:           Route{Path: "/sessions"},
+			Refresh:         Route{Disabled: true},
+		},
+		Auth: &CustomAuthConfig{
+			Jwt:       mjwt.NewImpl([]byte("secret"), time.Hour),
+			UserStore: newTestUserStore(t),
+		},
+	})
+
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodGet, "/svc-a/api/v2/healthz", nil, nil).Code)
+	assertions.Equal(http.StatusUnauthorized, serveJSON(r, http.MethodGet, "/healthz", nil, nil).Code)
+	assertions.Equal(http.StatusUnauthorized, serveJSON(r, http.MethodPost, AuthLoginPath, nil, nil).Code)
+
+	recorder := serveJSON(r, http.MethodPost, "/svc-a/api/v2/sessions", map[string]string{
+		"username": "admin",
+		"password": "password",
+	}, nil)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	header := http.Header{"Authorization": {"Bearer " + recorder.Header().Get("Authorization")}}
+
+	assertions.Equal(http.StatusOK, serveJSON(r, http.MethodGet, "/svc-a/api/v2"+AuthUserRoute, nil, header).Code)
+	assertions.Equal(http.StatusNotFound, serveJSON(r, http.MethodGet, "/svc-a/api/v2"+AuthRefreshRoute, nil, header).Code)
+
+	assertions.Equal([]string{"/healthz", "/api/v1/healthz"}, Routes{}.healthCheckPaths(APIPath))
+}
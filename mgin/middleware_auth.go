@@ -1,26 +1,230 @@
 package mgin
 
 import (
-	"github.com/kacifer/mc"
+	"errors"
+	"fmt"
 	"github.com/kacifer/mc/mjwt"
-	"net/http"
+	"path"
+	"strings"
 )
 
+// AuthRules tells CreateAuthMiddlewareWithRules which requests do not require a token,
+// routes marked with Public or OptionalAuth at registration take precedence over them.
+//
+// A rule is a path, optionally preceded by a method, e.g. "GET /share/:token".
+// It matches the request path or the route path, a trailing "/*" matches the path and everything below it,
+// and "*", "?" and "[...]" match as in path.Match.
+type AuthRules struct {
+	// SkipPaths are served without checking the token.
+	SkipPaths []string
+
+	// OptionalPaths accept anonymous callers, a valid token still sets the user ID and claims.
+	OptionalPaths []string
+
+	// Sources are where the token is looked up, in order, defaults to BearerTokenSource.
+	Sources []TokenSource
+}
+
+func (rules *AuthRules) sources() []TokenSource {
+	if len(rules.Sources) == 0 {
+		return []TokenSource{BearerTokenSource}
+	}
+	return rules.Sources
+}
+
+// Locations of a TokenSource.
+const (
+	TokenInHeader = "header"
+	TokenInCookie = "cookie"
+	TokenInQuery  = "query"
+)
+
+// TokenSourceKey is the context key of the TokenSource the token of the request was found in.
+const TokenSourceKey = "token_source"
+
+// TokenSource is where the auth middleware looks up the token, a header, a cookie or a query parameter.
+// Headers may prefix the token with the Bearer scheme, the Authorization header requires it.
+type TokenSource struct {
+	In   string
+	Name string
+}
+
+// BearerTokenSource is the Authorization header with the Bearer scheme.
+var BearerTokenSource = TokenSource{In: TokenInHeader, Name: "Authorization"}
+
+func CookieTokenSource(name string) TokenSource {
+	return TokenSource{In: TokenInCookie, Name: name}
+}
+
+func QueryTokenSource(name string) TokenSource {
+	return TokenSource{In: TokenInQuery, Name: name}
+}
+
 func CreateAuthMiddleware(jwt mjwt.Engine, skipAuthPaths []string) HandlerFunc {
+	return CreateAuthMiddlewareWithRules(jwt, AuthRules{SkipPaths: skipAuthPaths})
+}
+
+// CreateAuthMiddlewareWithRules authenticates requests, except public routes and those matching rules.SkipPaths.
+// On optional routes a request without a token passes anonymously,
+// but an invalid token is still rejected, so the client learns it has to sign in again.
+// A request authenticated by a cookie is also checked for cross-site requests, see CookieConfig.
+func CreateAuthMiddlewareWithRules(jwt mjwt.Engine, rules AuthRules) HandlerFunc {
 	return func(c *Context) {
-		if !mc.SliceContains(skipAuthPaths, c.Request.URL.Path) {
-			_, claims, err := jwt.ValidateHeader(c.Request.Header.Get("Authorization"))
-			if err != nil {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, &E{
-					Code:    http.StatusUnauthorized,
-					Message: err.Error(),
-				})
-				return
+		access := routeAccess(c.HandlerNames())
+		if access == accessRequired {
+			if matchAuthRules(rules.SkipPaths, c) {
+				access = accessPublic
+			} else if matchAuthRules(rules.OptionalPaths, c) {
+				access = accessOptional
 			}
+		}
+
+		if access == accessPublic {
+			c.Next()
+			return
+		}
+
+		tokenString, source, err := lookupToken(c, &rules)
+		if errors.Is(err, mjwt.ErrAuthHeaderMissing) && access == accessOptional {
+			c.Next()
+			return
+		}
+		if err != nil {
+			c.AbortAndWriteAuthError(err)
+			return
+		}
+
+		_, claims, err := jwt.ValidateSignedString(tokenString)
+		if err != nil {
+			c.AbortAndWriteAuthError(err)
+			return
+		}
+		if typ, _ := claims[mjwt.TypeKey].(string); typ == mjwt.TypeMFAPending {
+			c.AbortAndWriteAuthError(ErrMFAPending)
+			return
+		}
+		if source.In == TokenInCookie && !c.checkOrigin() {
+			return
+		}
 
-			c.Set(IDKey, claims[mjwt.IDKey])
+		if id, err := claims.UserID(); err == nil {
+			c.Set(IDKey, id)
 		}
+		c.Set(ClaimsKey, claims)
+		c.Set(TokenSourceKey, source)
 
 		c.Next()
 	}
 }
+
+// lookupToken returns the token of the first of sources the request has, mjwt.ErrAuthHeaderMissing if it has none.
+func lookupToken(c *Context, rules *AuthRules) (string, TokenSource, error) {
+	for _, source := range rules.sources() {
+		var value string
+		switch source.In {
+		case TokenInHeader:
+			value = c.GetHeader(source.Name)
+		case TokenInCookie:
+			value, _ = c.Cookie(source.Name)
+		case TokenInQuery:
+			value = c.Query(source.Name)
+		}
+		if value == "" {
+			continue
+		}
+		if source.In == TokenInHeader {
+			tokenString, err := mjwt.BearerToken(value)
+			if err != nil {
+				if strings.EqualFold(source.Name, BearerTokenSource.Name) {
+					return "", source, err
+				}
+				tokenString = value
+			}
+			value = tokenString
+		}
+		return value, source, nil
+	}
+	return "", TokenSource{}, mjwt.ErrAuthHeaderMissing
+}
+
+// matchAuthRules matches the rules against the route gin dispatched to, and the request path as long as it has
+// no dot segments, /docs/../public/x is routed to /docs/*path and must not match a /public/* rule.
+func matchAuthRules(rules []string, c *Context) bool {
+	requestPath := c.Request.URL.Path
+	cleaned := path.Clean("/" + requestPath)
+	if strings.HasSuffix(requestPath, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	if cleaned != requestPath {
+		requestPath = ""
+	}
+	return matchRoute(rules, c.Request.Method, requestPath, c.FullPath())
+}
+
+// matchRoute reports whether one of the rules matches the method and one of the paths.
+func matchRoute(rules []string, method string, paths ...string) bool {
+	for _, rule := range rules {
+		pattern := rule
+		if m, p, ok := strings.Cut(rule, " "); ok {
+			if m != method {
+				continue
+			}
+			pattern = strings.TrimSpace(p)
+		}
+		for _, p := range paths {
+			if p != "" && matchPath(pattern, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchPath(pattern string, p string) bool {
+	if pattern == p {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := path.Match(pattern, p)
+		return matched
+	}
+	return false
+}
+
+// AbortAndWriteAuthError aborts the context with an error returned by mjwt validation,
+// with a WWW-Authenticate header as described in RFC 6750.
+func (c *Context) AbortAndWriteAuthError(err error) {
+	code, bearerError := CodeTokenInvalid, "invalid_token"
+	switch {
+	case errors.Is(err, mjwt.ErrAuthHeaderMissing):
+		code, bearerError = CodeTokenMissing, ""
+	case errors.Is(err, mjwt.ErrAuthHeaderMalformed):
+		code, bearerError = CodeAuthHeaderMalformed, "invalid_request"
+	case errors.Is(err, mjwt.ErrTokenMalformed):
+		code = CodeTokenMalformed
+	case errors.Is(err, mjwt.ErrSignatureInvalid):
+		code = CodeTokenSignatureInvalid
+	case errors.Is(err, mjwt.ErrTokenExpired):
+		code = CodeTokenExpired
+	case errors.Is(err, mjwt.ErrTokenNotValidYet):
+		code = CodeTokenNotValidYet
+	case errors.Is(err, mjwt.ErrInvalidAudience):
+		code = CodeTokenAudienceInvalid
+	case errors.Is(err, mjwt.ErrTokenRevoked):
+		code = CodeTokenRevoked
+	case errors.Is(err, ErrMFAPending):
+		code = CodeMFARequired
+	}
+
+	if bearerError == "" {
+		c.Header("WWW-Authenticate", "Bearer")
+	} else {
+		c.Header("WWW-Authenticate", fmt.Sprintf("Bearer error=%q, error_description=%q", bearerError, err.Error()))
+	}
+	e := NewError(code, nil)
+	e.Message = err.Error()
+	c.AbortAndWriteError(e.Status(), e)
+}
@@ -0,0 +1,71 @@
+package mgin
+
+import (
+	"fmt"
+	"github.com/kacifer/mc"
+	"github.com/kacifer/mc/mjwt"
+	"net/http"
+	"strings"
+)
+
+// RequireRoles allows the request only if its token carries every one of roles,
+// it must run after CreateAuthMiddleware, and can be attached to a route or a group.
+func RequireRoles(roles ...string) HandlerFunc {
+	return func(c *Context) {
+		if missing := missingValues(c.Claims().Roles(), roles); len(missing) > 0 {
+			c.abortAndWriteForbidden(CodeInsufficientRole, "roles", missing)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAnyRole allows the request if its token carries at least one of roles.
+func RequireAnyRole(roles ...string) HandlerFunc {
+	return func(c *Context) {
+		granted := c.Claims().Roles()
+		for _, role := range roles {
+			if mc.SliceContains(granted, role) {
+				c.Next()
+				return
+			}
+		}
+		c.abortAndWriteForbidden(CodeInsufficientRole, "roles", roles)
+	}
+}
+
+// RequireScopes allows the request only if its token carries every one of scopes,
+// otherwise it responds 403 with an insufficient_scope WWW-Authenticate header as described in RFC 6750.
+func RequireScopes(scopes ...string) HandlerFunc {
+	return func(c *Context) {
+		if missing := missingValues(c.Claims().Scopes(), scopes); len(missing) > 0 {
+			c.Header("WWW-Authenticate", fmt.Sprintf("Bearer error=\"insufficient_scope\", scope=%q", strings.Join(scopes, " ")))
+			c.abortAndWriteForbidden(CodeInsufficientScope, "scopes", missing)
+			return
+		}
+		c.Next()
+	}
+}
+
+// abortAndWriteForbidden responds 403 with the missing permissions in the details,
+// or 401 if the request is not authenticated at all.
+func (c *Context) abortAndWriteForbidden(code int, kind string, missing []string) {
+	if c.Claims() == nil {
+		c.AbortAndWriteAuthError(mjwt.ErrAuthHeaderMissing)
+		return
+	}
+	e := NewError(code, ErrorDetails{kind: missing})
+	e.Message = fmt.Sprintf("missing required %s: %s", kind, strings.Join(missing, ", "))
+	e.Params = map[string]any{"missing": strings.Join(missing, ", ")}
+	c.AbortAndWriteError(http.StatusForbidden, e)
+}
+
+func missingValues(granted []string, required []string) []string {
+	var missing []string
+	for _, v := range required {
+		if !mc.SliceContains(granted, v) {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,123 @@
+package mgin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/kacifer/mc"
+	"net/http"
+	"strings"
+)
+
+const (
+	DefaultCSRFCookieName = "csrf_token"
+	DefaultCSRFHeaderName = "X-CSRF-Token"
+)
+
+// CSRFConfig configures CreateCSRFMiddleware.
+type CSRFConfig struct {
+	// CookieName defaults to DefaultCSRFCookieName, the cookie is readable by scripts.
+	CookieName string
+
+	// HeaderName is the request header the token is submitted in, and the response header it is issued in,
+	// defaults to DefaultCSRFHeaderName.
+	HeaderName string
+
+	// FormField, if set, is the form field the token can also be submitted in, e.g. by HTML forms.
+	FormField string
+
+	// Secret, if set, signs the tokens, so that a cookie planted by a sibling subdomain is not accepted.
+	Secret []byte
+
+	// Path defaults to "/".
+	Path   string
+	Domain string
+
+	// Insecure drops the Secure attribute, e.g. for development over plain http.
+	Insecure bool
+
+	// SameSite defaults to http.SameSiteStrictMode.
+	SameSite http.SameSite
+}
+
+func (config *CSRFConfig) cookieName() string {
+	return mc.VarOr(config.CookieName, DefaultCSRFCookieName)
+}
+
+func (config *CSRFConfig) headerName() string {
+	return mc.VarOr(config.HeaderName, DefaultCSRFHeaderName)
+}
+
+func (config *CSRFConfig) newToken() string {
+	token := randomToken(32)
+	if len(config.Secret) > 0 {
+		token += "." + config.sign(token)
+	}
+	return token
+}
+
+func (config *CSRFConfig) sign(value string) string {
+	mac := hmac.New(sha256.New, config.Secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// valid reports whether token was issued by newToken, any non-empty token is valid without a Secret.
+func (config *CSRFConfig) valid(token string) bool {
+	if token == "" || len(config.Secret) == 0 {
+		return token != ""
+	}
+	value, signature, ok := strings.Cut(token, ".")
+	return ok && hmac.Equal([]byte(signature), []byte(config.sign(value)))
+}
+
+// CreateCSRFMiddleware protects cookie-authenticated requests against cross-site request forgery with double-submit tokens.
+//
+// Every response carries the token in a cookie readable by scripts and in a response header, the token is kept
+// as long as the cookie is. Unsafe requests authenticated by a cookie must submit it in the header or the form field,
+// otherwise they are rejected with CodeCSRFTokenInvalid. Requests authenticated otherwise, e.g. by a Bearer header,
+// are not affected, so it must run after the auth middleware.
+func CreateCSRFMiddleware(config *CSRFConfig) HandlerFunc {
+	return func(c *Context) {
+		token, _ := c.Cookie(config.cookieName())
+		if !config.valid(token) {
+			token = config.newToken()
+			http.SetCookie(c.Writer, &http.Cookie{
+				Name:     config.cookieName(),
+				Value:    token,
+				Path:     mc.VarOr(config.Path, "/"),
+				Domain:   config.Domain,
+				Secure:   !config.Insecure,
+				SameSite: mc.VarOr(config.SameSite, http.SameSiteStrictMode),
+			})
+		}
+		c.Header(config.headerName(), token)
+
+		if !c.authenticatedByCookie() {
+			c.Next()
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			c.Next()
+			return
+		}
+
+		submitted := c.GetHeader(config.headerName())
+		if submitted == "" && config.FormField != "" {
+			submitted = c.PostForm(config.FormField)
+		}
+		if submitted == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+			c.AbortAndWriteError(0, CodeCSRFTokenInvalid)
+			return
+		}
+		c.Next()
+	}
+}
+
+// authenticatedByCookie reports whether the auth middleware found the token of the request in a cookie.
+func (c *Context) authenticatedByCookie() bool {
+	source, ok := c.Value(TokenSourceKey).(TokenSource)
+	return ok && source.In == TokenInCookie
+}
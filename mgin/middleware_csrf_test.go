@@ -0,0 +1,80 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateCSRFMiddleware(t *testing.T) {
+	assertions := require.New(t)
+
+	jwt := mjwt.NewImpl([]byte("secret"), time.Hour)
+	signed, err := jwt.SignedStringForID(1)
+	assertions.Nil(err)
+
+	r := New()
+	r.UseAuth(jwt, AuthRules{Sources: []TokenSource{BearerTokenSource, CookieTokenSource(DefaultTokenCookieName)}})
+	r.Use(CreateCSRFMiddleware(&CSRFConfig{Secret: []byte("csrf secret"), FormField: "csrf_token"}))
+	r.GET("/posts", func(c *Context) {
+		c.JSON(http.StatusOK, "OK")
+	})
+	r.POST("/posts", func(c *Context) {
+		c.JSON(http.StatusOK, "OK")
+	})
+
+	// the token is issued in a cookie readable by scripts and in a header
+	authCookie := DefaultTokenCookieName + "=" + signed
+	recorder := serveJSON(r, http.MethodGet, "/posts", nil, http.Header{"Cookie": {authCookie}})
+	assertions.Equal(http.StatusOK, recorder.Code)
+	cookie := findCookie(recorder, DefaultCSRFCookieName)
+	assertions.NotNil(cookie)
+	assertions.False(cookie.HttpOnly)
+	token := cookie.Value
+	assertions.Equal(token, recorder.Header().Get(DefaultCSRFHeaderName))
+	assertions.Contains(token, ".")
+
+	cookies := authCookie + "; " + DefaultCSRFCookieName + "=" + token
+
+	// the token is kept as long as the cookie is
+	recorder = serveJSON(r, http.MethodGet, "/posts", nil, http.Header{"Cookie": {cookies}})
+	assertions.Nil(findCookie(recorder, DefaultCSRFCookieName))
+	assertions.Equal(token, recorder.Header().Get(DefaultCSRFHeaderName))
+
+	// unsafe requests authenticated by the cookie must submit the token
+	recorder = serveJSON(r, http.MethodPost, "/posts", nil, http.Header{"Cookie": {cookies}})
+	assertions.Equal(http.StatusForbidden, recorder.Code)
+	var e E
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+	assertions.Equal(CodeCSRFTokenInvalid, e.Code)
+	assertions.Equal(KeyCSRFTokenInvalid, e.Key)
+
+	recorder = serveJSON(r, http.MethodPost, "/posts", nil, http.Header{"Cookie": {cookies}, DefaultCSRFHeaderName: {"other"}})
+	assertions.Equal(http.StatusForbidden, recorder.Code)
+
+	recorder = serveJSON(r, http.MethodPost, "/posts", nil, http.Header{"Cookie": {cookies}, DefaultCSRFHeaderName: {token}})
+	assertions.Equal(http.StatusOK, recorder.Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/posts", strings.NewReader(url.Values{"csrf_token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Cookie", cookies)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assertions.Equal(http.StatusOK, recorder.Code)
+
+	// a planted cookie without a valid signature is replaced
+	forged := authCookie + "; " + DefaultCSRFCookieName + "=forged"
+	recorder = serveJSON(r, http.MethodPost, "/posts", nil, http.Header{"Cookie": {forged}, DefaultCSRFHeaderName: {"forged"}})
+	assertions.Equal(http.StatusForbidden, recorder.Code)
+	assertions.NotEqual("forged", findCookie(recorder, DefaultCSRFCookieName).Value)
+
+	// Bearer requests are not checked
+	recorder = serveJSON(r, http.MethodPost, "/posts", nil, http.Header{"Authorization": {"Bearer " + signed}})
+	assertions.Equal(http.StatusOK, recorder.Code)
+}
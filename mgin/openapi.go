@@ -0,0 +1,485 @@
+package mgin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const OpenAPIPath = "/openapi.json"
+
+// OpenAPIConfig describes the API in the OpenAPI document, Path is where Custom serves it, defaults to OpenAPIPath.
+type OpenAPIConfig struct {
+	Title       string
+	Version     string
+	Description string
+	Path        string
+}
+
+// Operation documents a route in the OpenAPI document.
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+
+	// Request and Response are values of the request and response types, their schemas are reflected:
+	// uri tags are path params, form tags are query params and the other fields are the JSON body.
+	Request  any
+	Response any
+}
+
+// HandleTyped registers f as a Typed handler after the middlewares, and documents the route with Req and Resp.
+func HandleTyped[Req any, Resp any](router Router, httpMethod, relativePath string, op Operation, f func(*Context, Req) (Resp, error), middlewares ...HandlerFunc) {
+	var req Req
+	var resp Resp
+	op.Request = req
+	op.Response = resp
+	router.Document(httpMethod, relativePath, op)
+	router.Handle(httpMethod, relativePath, append(middlewares, Typed(f))...)
+}
+
+// Router is implemented by Engine and RouterGroup.
+type Router interface {
+	Handle(httpMethod, relativePath string, handlers ...HandlerFunc)
+	Document(httpMethod, relativePath string, op Operation)
+}
+
+type routeDoc struct {
+	method     string
+	path       string
+	access     access
+	registered bool
+	op         Operation
+}
+
+type routeRegistry struct {
+	routes map[string]*routeDoc
+	// auth is nil if the engine has no auth middleware.
+	auth *AuthRules
+}
+
+func (engine *Engine) registry() *routeRegistry {
+	if engine.routes == nil {
+		engine.routes = &routeRegistry{routes: map[string]*routeDoc{}}
+	}
+	return engine.routes
+}
+
+func (r *routeRegistry) get(method, path string) *routeDoc {
+	key := method + " " + path
+	route, ok := r.routes[key]
+	if !ok {
+		route = &routeDoc{method: method, path: path}
+		r.routes[key] = route
+	}
+	return route
+}
+
+func (r *routeRegistry) add(method, path string, access access) {
+	route := r.get(method, path)
+	route.registered = true
+	route.access = access
+}
+
+func (r *routeRegistry) document(method, path string, op Operation) {
+	r.get(method, path).op = op
+}
+
+// OpenAPIDocument is an OpenAPI 3.1 document.
+type OpenAPIDocument struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       OpenAPIInfo                `json:"info"`
+	Paths      map[string]OpenAPIPathItem `json:"paths"`
+	Components OpenAPIComponents          `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIPathItem maps lower case methods to operations.
+type OpenAPIPathItem map[string]*OpenAPIOperation
+
+type OpenAPIOperation struct {
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+	Security    *[]map[string][]string      `json:"security,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema                `json:"schemas"`
+	SecuritySchemes map[string]*OpenAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type OpenAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+// Schema is a JSON schema, as used by OpenAPI 3.1.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+const bearerAuth = "bearerAuth"
+
+var errorSchemaRef = &Schema{Ref: "#/components/schemas/E"}
+
+// OpenAPI builds the OpenAPI document of the routes registered so far.
+func (engine *Engine) OpenAPI(config OpenAPIConfig) *OpenAPIDocument {
+	registry := engine.registry()
+	doc := &OpenAPIDocument{
+		OpenAPI: "3.1.0",
+		Info: OpenAPIInfo{
+			Title:       config.Title,
+			Version:     config.Version,
+			Description: config.Description,
+		},
+		Paths: map[string]OpenAPIPathItem{},
+		Components: OpenAPIComponents{
+			Schemas: map[string]*Schema{},
+		},
+	}
+	schemas := &schemaBuilder{schemas: doc.Components.Schemas, names: map[reflect.Type]string{}}
+	schemas.schema(reflect.TypeOf(E{}))
+	if registry.auth != nil {
+		doc.Components.SecuritySchemes = map[string]*OpenAPISecurityScheme{}
+		for _, source := range registry.auth.sources() {
+			if source == BearerTokenSource {
+				doc.Components.SecuritySchemes[bearerAuth] = &OpenAPISecurityScheme{Type: "http", Scheme: "bearer", BearerFormat: "JWT"}
+			} else {
+				doc.Components.SecuritySchemes[securitySchemeName(source)] = &OpenAPISecurityScheme{Type: "apiKey", In: source.In, Name: source.Name}
+			}
+		}
+	}
+
+	for _, route := range registry.routes {
+		if !route.registered {
+			continue
+		}
+		p := openAPIPath(route.path)
+		if doc.Paths[p] == nil {
+			doc.Paths[p] = OpenAPIPathItem{}
+		}
+		doc.Paths[p][strings.ToLower(route.method)] = registry.operation(route, schemas)
+	}
+	return doc
+}
+
+func (r *routeRegistry) operation(route *routeDoc, schemas *schemaBuilder) *OpenAPIOperation {
+	op := &OpenAPIOperation{
+		Summary:     route.op.Summary,
+		Description: route.op.Description,
+		Tags:        route.op.Tags,
+		Responses: map[string]*OpenAPIResponse{
+			"200": {Description: "OK"},
+			"default": {
+				Description: "Error",
+				Content:     map[string]*OpenAPIMediaType{"application/json": {Schema: errorSchemaRef}},
+			},
+		},
+	}
+
+	params := map[string]*OpenAPIParameter{}
+	for _, name := range pathParams(route.path) {
+		params[name] = &OpenAPIParameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}}
+	}
+
+	if route.op.Request != nil {
+		t := indirect(reflect.TypeOf(route.op.Request))
+		body := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		if t.Kind() == reflect.Struct {
+			for _, field := range structFields(t) {
+				required := strings.Contains(field.Tag.Get("binding"), "required")
+				if name := tagName(field, "uri"); name != "" {
+					if param, ok := params[name]; ok {
+						param.Schema = schemas.schema(field.Type)
+					}
+				} else if name := tagName(field, "form"); name != "" {
+					params[name] = &OpenAPIParameter{Name: name, In: "query", Required: required, Schema: schemas.schema(field.Type)}
+				} else if name := jsonName(field); name != "" {
+					body.Properties[name] = schemas.schema(field.Type)
+					if required {
+						body.Required = append(body.Required, name)
+					}
+				}
+			}
+		} else {
+			body = schemas.schema(t)
+		}
+		if len(body.Properties) > 0 || body.Type != "object" {
+			op.RequestBody = &OpenAPIRequestBody{
+				Required: len(body.Required) > 0,
+				Content:  map[string]*OpenAPIMediaType{"application/json": {Schema: body}},
+			}
+		}
+	}
+
+	for _, param := range params {
+		op.Parameters = append(op.Parameters, param)
+	}
+	sort.Slice(op.Parameters, func(i, j int) bool {
+		if op.Parameters[i].In != op.Parameters[j].In {
+			return op.Parameters[i].In == "path"
+		}
+		return op.Parameters[i].Name < op.Parameters[j].Name
+	})
+
+	if route.op.Response != nil {
+		op.Responses["200"].Content = map[string]*OpenAPIMediaType{
+			"application/json": {Schema: schemas.schema(reflect.TypeOf(route.op.Response))},
+		}
+	}
+
+	if r.auth != nil {
+		access := route.access
+		if access == accessRequired {
+			if matchRoute(r.auth.SkipPaths, route.method, route.path) {
+				access = accessPublic
+			} else if matchRoute(r.auth.OptionalPaths, route.method, route.path) {
+				access = accessOptional
+			}
+		}
+		security := []map[string][]string{}
+		if access != accessPublic {
+			for _, source := range r.auth.sources() {
+				security = append(security, map[string][]string{securitySchemeName(source): {}})
+			}
+		}
+		if access == accessOptional {
+			security = append(security, map[string][]string{})
+		}
+		op.Security = &security
+		if access != accessPublic {
+			op.Responses["401"] = &OpenAPIResponse{
+				Description: "Unauthorized",
+				Content:     map[string]*OpenAPIMediaType{"application/json": {Schema: errorSchemaRef}},
+			}
+		}
+	}
+	return op
+}
+
+// securitySchemeName returns bearerAuth for BearerTokenSource, e.g. cookieAuth_access_token for the others.
+func securitySchemeName(source TokenSource) string {
+	if source == BearerTokenSource {
+		return bearerAuth
+	}
+	return source.In + "Auth_" + source.Name
+}
+
+var pathParamPattern = regexp.MustCompile(`[:*]([^/]+)`)
+
+// openAPIPath converts the gin params of a path to OpenAPI ones, /posts/:id becomes /posts/{id}.
+func openAPIPath(p string) string {
+	return pathParamPattern.ReplaceAllString(p, "{$1}")
+}
+
+func pathParams(p string) []string {
+	var names []string
+	for _, match := range pathParamPattern.FindAllStringSubmatch(p, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// JSON encodes the document with indentation, so that it diffs well.
+func (d *OpenAPIDocument) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// WriteFile writes the document to the file, e.g. from a test to keep a checked-in copy up to date.
+func (d *OpenAPIDocument) WriteFile(name string) error {
+	data, err := d.JSON()
+	if err != nil {
+		return errors.Wrap(err, "encode OpenAPI document error")
+	}
+	return os.WriteFile(name, append(data, '\n'), 0644)
+}
+
+// CreateOpenAPIHandler serves the OpenAPI document of the engine, it is built on each request,
+// so routes registered after the handler are included.
+func CreateOpenAPIHandler(engine *Engine, config OpenAPIConfig) HandlerFunc {
+	return func(c *Context) {
+		c.JSON(http.StatusOK, engine.OpenAPI(config))
+	}
+}
+
+type schemaBuilder struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// schema returns the schema of t, named struct types are added to the components and referenced.
+func (b *schemaBuilder) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	t = indirect(t)
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &Schema{}
+	case reflect.PointerTo(t).Implements(jsonMarshalerType) || t.Implements(jsonMarshalerType):
+		// custom encodings are not reflected
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name, ok := b.names[t]
+		if !ok {
+			name = schemaName(t)
+			// types of different packages or functions may share a name
+			for i := 2; b.schemas[name] != nil; i++ {
+				name = fmt.Sprintf("%s%d", schemaName(t), i)
+			}
+			// reserve the name first, for recursive types
+			b.names[t] = name
+			b.schemas[name] = &Schema{}
+			*b.schemas[name] = *b.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		return &Schema{}
+	}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for _, field := range structFields(t) {
+		name := jsonName(field)
+		if name == "" {
+			continue
+		}
+		schema.Properties[name] = b.schema(field.Type)
+		if strings.Contains(field.Tag.Get("binding"), "required") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+var schemaNameReplacer = strings.NewReplacer("[", "_", "]", "", "/", "_", ",", "_", "*", "", " ", "")
+
+// schemaName returns the component name of a named type, the names of generic types are sanitized.
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if i := strings.Index(name, "["); i >= 0 {
+		args := name[i:]
+		for _, part := range strings.Split(strings.Trim(args, "[]"), ",") {
+			args = strings.Replace(args, part, part[strings.LastIndex(part, ".")+1:], 1)
+		}
+		name = name[:i] + args
+	}
+	return schemaNameReplacer.Replace(name)
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// structFields returns the exported fields of t, the fields of embedded structs are promoted.
+func structFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && indirect(field.Type).Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+			fields = append(fields, structFields(indirect(field.Type))...)
+			continue
+		}
+		if field.IsExported() {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func tagName(field reflect.StructField, tag string) string {
+	name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+func jsonName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+	return field.Name
+}
@@ -0,0 +1,102 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testPost struct {
+	ID       uint       `json:"id"`
+	Title    string     `json:"title"`
+	Tags     []string   `json:"tags,omitempty"`
+	Author   *testUser  `json:"author,omitempty"`
+	Replies  []testPost `json:"replies,omitempty"`
+	Created  time.Time  `json:"created"`
+	internal string
+}
+
+type testUpdatePostRequest struct {
+	ID     uint   `uri:"id"`
+	Notify bool   `form:"notify"`
+	Title  string `json:"title" binding:"required"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	assertions := require.New(t)
+
+	r := Custom(CustomConfig{
+		OpenAPI: &OpenAPIConfig{Title: "test", Version: "1.0.0"},
+		Auth: &CustomAuthConfig{
+			Jwt:           mjwt.NewImpl([]byte("secret"), time.Hour),
+			UserStore:     newTestUserStore(t),
+			SkipAuthPaths: []string{"GET /api/v1/posts/*"},
+		},
+	})
+	posts := r.Group("/api/v1/posts")
+	HandleTyped(posts, http.MethodPut, "/:id", Operation{Summary: "Update a post", Tags: []string{"posts"}},
+		func(c *Context, req testUpdatePostRequest) (*testPost, error) {
+			return &testPost{ID: req.ID, Title: req.Title}, nil
+		}, RequireRoles("editor"))
+	posts.GET("/:id", func(c *Context) {
+		c.JSON(200, "OK")
+	})
+	r.GET("/feed", OptionalAuth, func(c *Context) {
+		c.JSON(200, "OK")
+	})
+
+	recorder := serveJSON(r, http.MethodGet, OpenAPIPath, nil, nil)
+	assertions.Equal(http.StatusOK, recorder.Code)
+
+	var doc map[string]any
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &doc))
+	assertions.Equal("3.1.0", doc["openapi"])
+
+	paths := doc["paths"].(map[string]any)
+	put := paths["/api/v1/posts/{id}"].(map[string]any)["put"].(map[string]any)
+	assertions.Equal("Update a post", put["summary"])
+	assertions.Equal([]any{map[string]any{"bearerAuth": []any{}}}, put["security"])
+	assertions.Equal([]any{
+		map[string]any{"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "integer"}},
+		map[string]any{"name": "notify", "in": "query", "schema": map[string]any{"type": "boolean"}},
+	}, put["parameters"])
+	assertions.Equal(map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"title": map[string]any{"type": "string"}},
+		"required":   []any{"title"},
+	}, put["requestBody"].(map[string]any)["content"].(map[string]any)["application/json"].(map[string]any)["schema"])
+	responses := put["responses"].(map[string]any)
+	assertions.Equal(map[string]any{"$ref": "#/components/schemas/testPost"},
+		responses["200"].(map[string]any)["content"].(map[string]any)["application/json"].(map[string]any)["schema"])
+	assertions.Contains(responses, "401")
+
+	// security follows the skip rules and the route markers
+	get := paths["/api/v1/posts/{id}"].(map[string]any)["get"].(map[string]any)
+	assertions.Equal([]any{}, get["security"])
+	feed := paths["/feed"].(map[string]any)["get"].(map[string]any)
+	assertions.Equal([]any{map[string]any{"bearerAuth": []any{}}, map[string]any{}}, feed["security"])
+	login := paths[AuthLoginPath].(map[string]any)["post"].(map[string]any)
+	assertions.Equal([]any{}, login["security"])
+	assertions.Contains(login["requestBody"].(map[string]any)["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)["properties"], "username")
+	assertions.Contains(paths, "/healthz")
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	assertions.Contains(schemas, "E")
+	post := schemas["testPost"].(map[string]any)["properties"].(map[string]any)
+	assertions.Equal(map[string]any{"type": "string", "format": "date-time"}, post["created"])
+	assertions.Equal(map[string]any{"type": "array", "items": map[string]any{"$ref": "#/components/schemas/testPost"}}, post["replies"])
+	assertions.Equal(map[string]any{"$ref": "#/components/schemas/testUser"}, post["author"])
+	assertions.NotContains(post, "internal")
+
+	// the document can be exported to a file and diffed in review
+	name := filepath.Join(t.TempDir(), "openapi.json")
+	assertions.Nil(r.OpenAPI(OpenAPIConfig{Title: "test", Version: "1.0.0"}).WriteFile(name))
+	data, err := os.ReadFile(name)
+	assertions.Nil(err)
+	assertions.JSONEq(recorder.Body.String(), string(data))
+}
@@ -0,0 +1,117 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/kacifer/mc"
+	"net/http"
+	"strings"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// ProblemKey is the context key of the *ProblemConfig set by CreateProblemMiddleware.
+const ProblemKey = "problem"
+
+// ProblemConfig makes errors RFC 7807 problem documents for the clients which accept application/problem+json,
+// the other clients still get E.
+type ProblemConfig struct {
+	// TypeBase is prefixed to E.Key to form the type URI, e.g. https://example.com/problems/,
+	// the type is about:blank if it or the key is empty.
+	TypeBase string
+
+	// Always writes problem documents regardless of the Accept header.
+	Always bool
+}
+
+// CreateProblemMiddleware makes AbortAndWriteError write problem documents, see ProblemConfig.
+func CreateProblemMiddleware(config *ProblemConfig) HandlerFunc {
+	return func(c *Context) {
+		c.Set(ProblemKey, config)
+		c.Next()
+	}
+}
+
+// Problem is an RFC 7807 problem document, Extensions are written as members of the document.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+var problemMembers = []string{"type", "title", "status", "detail", "instance"}
+
+// NewProblem converts e into a problem document, its code and key, and its details are extension members.
+// Details which would overwrite a member are kept under the details member instead.
+func NewProblem(status int, e *E, instance string, config *ProblemConfig) *Problem {
+	p := &Problem{
+		Type:       "about:blank",
+		Title:      http.StatusText(status),
+		Status:     status,
+		Detail:     e.Message,
+		Instance:   instance,
+		Extensions: map[string]any{"code": e.Code},
+	}
+	if config != nil && config.TypeBase != "" && e.Key != "" {
+		p.Type = config.TypeBase + e.Key
+	}
+	if e.Key != "" {
+		p.Extensions["key"] = e.Key
+	}
+	conflicts := ErrorDetails{}
+	for k, v := range e.Details {
+		if _, ok := p.Extensions[k]; ok || mc.SliceContains(problemMembers, k) || k == "details" {
+			conflicts[k] = v
+			continue
+		}
+		p.Extensions[k] = v
+	}
+	if len(conflicts) > 0 {
+		p.Extensions["details"] = conflicts
+	}
+	return p
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+	return json.Marshal(members)
+}
+
+func (c *Context) problemConfig() *ProblemConfig {
+	config, _ := c.Value(ProblemKey).(*ProblemConfig)
+	return config
+}
+
+// wantsProblem reports whether errors are written as problem documents, see ProblemConfig.
+func (c *Context) wantsProblem() bool {
+	config := c.problemConfig()
+	if config == nil {
+		return false
+	}
+	if config.Always {
+		return true
+	}
+	for _, mediaType := range parseAcceptHeader(c.GetHeader("Accept")) {
+		switch strings.ToLower(mediaType) {
+		case ProblemContentType:
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestProblem(t *testing.T) {
+	assertions := require.New(t)
+
+	r := Custom(CustomConfig{
+		Problem: &ProblemConfig{TypeBase: "https://example.com/problems/"},
+		Auth: &CustomAuthConfig{
+			Jwt:       mjwt.NewImpl([]byte("secret"), time.Hour),
+			UserStore: newTestUserStore(t),
+		},
+	})
+
+	// the current format stays the default
+	recorder := serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{"username": "nobody"}, nil)
+	assertions.Equal(http.StatusUnprocessableEntity, recorder.Code)
+	assertions.Equal("application/json; charset=utf-8", recorder.Header().Get("Content-Type"))
+
+	recorder = serveJSON(r, http.MethodPost, AuthLoginPath, map[string]string{"username": "nobody"}, http.Header{
+		"Accept": {"application/problem+json, application/json;q=0.9"},
+	})
+	assertions.Equal(http.StatusUnprocessableEntity, recorder.Code)
+	assertions.Equal(ProblemContentType, recorder.Header().Get("Content-Type"))
+	var problem map[string]any
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &problem))
+	assertions.Equal(map[string]any{
+		"type":     "https://example.com/problems/" + KeyUsernameNotFound,
+		"title":    "Unprocessable Entity",
+		"status":   float64(http.StatusUnprocessableEntity),
+		"detail":   "username not exist",
+		"instance": AuthLoginPath,
+		"code":     float64(CodeUsernameNotFound),
+		"key":      KeyUsernameNotFound,
+		"username": "username not exist",
+	}, problem)
+
+	recorder = serveJSON(r, http.MethodGet, "/missing?x=1", nil, http.Header{"Accept": {ProblemContentType}})
+	assertions.Equal(http.StatusUnauthorized, recorder.Code)
+	assertions.Equal(ProblemContentType, recorder.Header().Get("Content-Type"))
+	problem = nil
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &problem))
+	assertions.Equal("/missing?x=1", problem["instance"])
+	assertions.Equal(float64(CodeTokenMissing), problem["code"])
+
+	// details which would overwrite members are nested
+	p := NewProblem(http.StatusBadRequest, &E{Code: 400, Message: "bad", Details: ErrorDetails{"status": "x", "name": "y"}}, "", nil)
+	assertions.Equal("about:blank", p.Type)
+	assertions.Equal(ErrorDetails{"status": "x"}, p.Extensions["details"])
+	assertions.Equal("y", p.Extensions["name"])
+
+	r = New()
+	r.Use(CreateProblemMiddleware(&ProblemConfig{Always: true}))
+	r.GET("/", func(c *Context) {
+		c.AbortAndWriteError(http.StatusConflict, "conflict")
+	})
+	recorder = serveJSON(r, http.MethodGet, "/", nil, nil)
+	assertions.Equal(ProblemContentType, recorder.Header().Get("Content-Type"))
+	assertions.JSONEq(`{"type":"about:blank","title":"Conflict","status":409,"detail":"conflict","instance":"/","code":409}`, recorder.Body.String())
+}
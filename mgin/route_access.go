@@ -0,0 +1,67 @@
+package mgin
+
+import (
+	"github.com/gin-gonic/gin"
+	"reflect"
+	"runtime"
+)
+
+type access int
+
+const (
+	accessRequired access = iota
+	accessPublic
+	accessOptional
+)
+
+// Public marks a route as public, the auth middleware lets every request through:
+//
+//	r.GET("/share/:token", mgin.Public, handler)
+func Public(c *Context) {
+	c.Next()
+}
+
+// OptionalAuth marks a route whose callers may be anonymous,
+// the auth middleware still sets the user ID and claims when a valid token is presented.
+func OptionalAuth(c *Context) {
+	c.Next()
+}
+
+// The markers are adapted to these named functions, so that the auth middleware can find them by name in the handlers chain.
+func publicMarker(c *gin.Context) {
+	c.Next()
+}
+
+func optionalAuthMarker(c *gin.Context) {
+	c.Next()
+}
+
+var markers = map[uintptr]gin.HandlerFunc{
+	funcPointer(Public):       publicMarker,
+	funcPointer(OptionalAuth): optionalAuthMarker,
+}
+
+func funcPointer(f any) uintptr {
+	return reflect.ValueOf(f).Pointer()
+}
+
+func funcName(f any) string {
+	return runtime.FuncForPC(funcPointer(f)).Name()
+}
+
+var publicMarkerName = funcName(publicMarker)
+var optionalAuthMarkerName = funcName(optionalAuthMarker)
+
+// routeAccess returns the access declared by the markers among the handlers of the matched route,
+// handlerNames are those of gin.Context.HandlerNames.
+func routeAccess(handlerNames []string) access {
+	for _, name := range handlerNames {
+		switch name {
+		case publicMarkerName:
+			return accessPublic
+		case optionalAuthMarkerName:
+			return accessOptional
+		}
+	}
+	return accessRequired
+}
@@ -0,0 +1,69 @@
+package mgin
+
+import "path"
+
+// Route configures a built-in route of Custom, the zero value keeps the default path.
+type Route struct {
+	// Path overrides the default path.
+	Path     string
+	Disabled bool
+}
+
+func (r Route) path(defaultPath string) string {
+	if r.Path == "" {
+		return defaultPath
+	}
+	return r.Path
+}
+
+// Routes configures the built-in routes of Custom.
+// HealthCheck and the auth and setting routes are relative to CustomConfig.BasePath,
+// RootHealthCheck and JWKS are absolute paths.
+type Routes struct {
+	HealthCheck     Route
+	RootHealthCheck Route
+	JWKS            Route
+
+	Login        Route
+	Refresh      Route
+	RefreshToken Route
+	User         Route
+	Logout       Route
+	SettingGet   Route
+	SettingSet   Route
+
+	// The account routes are registered only if CustomAuthConfig.Account enables them.
+	Register             Route
+	PasswordChange       Route
+	PasswordReset        Route
+	PasswordResetConfirm Route
+
+	// The two-factor authentication routes are registered only if CustomAuthConfig.TOTP is set.
+	MFAVerify   Route
+	TOTPEnroll  Route
+	TOTPEnable  Route
+	TOTPDisable Route
+}
+
+// healthCheckPaths returns the absolute paths of the enabled health check routes.
+func (r Routes) healthCheckPaths(basePath string) []string {
+	var paths []string
+	if !r.RootHealthCheck.Disabled {
+		paths = append(paths, r.RootHealthCheck.path(HealthCheckRoute))
+	}
+	if !r.HealthCheck.Disabled {
+		paths = append(paths, joinPaths(basePath, r.HealthCheck.path(HealthCheckRoute)))
+	}
+	return paths
+}
+
+func joinPaths(basePath string, relativePath string) string {
+	if relativePath == "" {
+		return basePath
+	}
+	joined := path.Join(basePath, relativePath)
+	if relativePath[len(relativePath)-1] == '/' && joined[len(joined)-1] != '/' {
+		return joined + "/"
+	}
+	return joined
+}
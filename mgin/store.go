@@ -1,6 +1,9 @@
 package mgin
 
-import "github.com/pkg/errors"
+import (
+	"github.com/pkg/errors"
+	"time"
+)
 
 type User interface {
 	GetID() uint
@@ -8,6 +11,18 @@ type User interface {
 	GetPassword() string
 }
 
+// RoleUser is a User with roles, they are signed into the roles claim of its tokens.
+type RoleUser interface {
+	User
+	GetRoles() []string
+}
+
+// ScopeUser is a User with scopes, they are signed into the scope claim of its tokens.
+type ScopeUser interface {
+	User
+	GetScopes() []string
+}
+
 var ErrUserIDNotFound = errors.New("user not found")
 var ErrUsernameNotFound = errors.New("username not found")
 
@@ -20,3 +35,88 @@ type SettingStore interface {
 	Get(userID uint, key string) (string, error)
 	Set(userID uint, key string, value string) error
 }
+
+// RefreshToken is a single-use refresh token, tokens exchanged from the same login share a FamilyID.
+type RefreshToken struct {
+	// Hash is the SHA-256 hex digest of the token, the token itself is never stored.
+	Hash      string
+	UserID    uint
+	FamilyID  string
+	ExpiresAt time.Time
+	Used      bool
+	Revoked   bool
+}
+
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+var ErrRefreshTokenUsed = errors.New("refresh token already used")
+
+type RefreshTokenStore interface {
+	Save(token *RefreshToken) error
+	Find(hash string) (*RefreshToken, error)
+	// MarkUsed marks the token as used, it returns ErrRefreshTokenUsed if the token has been used before.
+	MarkUsed(hash string) error
+	RevokeFamily(familyID string) error
+	RevokeUser(userID uint) error
+}
+
+// LoginAttempts are the recent failed logins of a username or a client IP.
+type LoginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// LoginAttemptStore counts failed logins by key, e.g. username:admin or ip:192.0.2.1.
+type LoginAttemptStore interface {
+	// Get returns the attempts of key, with no failures if there is none.
+	Get(key string) (*LoginAttempts, error)
+	// Reserve records a login at t as failed before it is checked, and returns the attempts as they were before it,
+	// the count starts over if the previous failure is older than window. It must be atomic.
+	Reserve(key string, t time.Time, window time.Duration) (*LoginAttempts, error)
+	// Release takes back the failure reserved at t, once the login has succeeded or has been throttled,
+	// LastFailure goes back to previous unless a failure has been reserved since t.
+	Release(key string, t time.Time, previous time.Time) error
+	Reset(key string) error
+}
+
+var ErrUsernameTaken = errors.New("username taken")
+var ErrPasswordResetTokenNotFound = errors.New("password reset token not found")
+
+// PasswordResetToken is a single-use token to set a new password without the current one.
+type PasswordResetToken struct {
+	// Hash is the SHA-256 hex digest of the token, the token itself is never stored.
+	Hash      string
+	UserID    uint
+	ExpiresAt time.Time
+}
+
+// AccountStore is a UserStore which can also create users and change their passwords, see AccountConfig.
+type AccountStore interface {
+	UserStore
+	// Create saves a new user, it returns ErrUsernameTaken if the username exists.
+	Create(username string, passwordHash string) (User, error)
+	UpdatePassword(userID uint, passwordHash string) error
+	SaveResetToken(token *PasswordResetToken) error
+	// ConsumeResetToken deletes the token and returns it, it returns ErrPasswordResetTokenNotFound if there is no such token.
+	// It must be atomic, so that concurrent requests can not both use the token.
+	ConsumeResetToken(hash string) (*PasswordResetToken, error)
+}
+
+var ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+var ErrTOTPCodeUsed = errors.New("totp code used")
+
+// TOTPStore is a UserStore whose users can enable TOTP two-factor authentication, see TOTPConfig.
+type TOTPStore interface {
+	UserStore
+	// TOTPSecret returns the base32 TOTP secret of the user, it is empty if two-factor authentication is disabled.
+	TOTPSecret(userID uint) (string, error)
+	// SetTOTPSecret enables two-factor authentication, an empty secret disables it.
+	SetTOTPSecret(userID uint, secret string) error
+	// SetRecoveryCodes replaces the recovery codes of the user, they are SHA-256 hex digests.
+	SetRecoveryCodes(userID uint, hashes []string) error
+	// UseRecoveryCode removes the recovery code, it returns ErrRecoveryCodeNotFound if the user has no such code.
+	UseRecoveryCode(userID uint, hash string) error
+	// UseTOTPCounter records the time step of an accepted code, it returns ErrTOTPCodeUsed
+	// if a code of the same or a later step has been accepted before, so that codes can not be replayed.
+	// It must be atomic.
+	UseTOTPCounter(userID uint, counter uint64) error
+}
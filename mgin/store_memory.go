@@ -0,0 +1,152 @@
+package mgin
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryRefreshTokenStore is an in-memory RefreshTokenStore, expired tokens are pruned on Save.
+type MemoryRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]RefreshToken
+
+	NowFunc func() time.Time
+}
+
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{
+		tokens:  map[string]RefreshToken{},
+		NowFunc: time.Now,
+	}
+}
+
+func (s *MemoryRefreshTokenStore) Save(token *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.NowFunc()
+	for hash, t := range s.tokens {
+		if !t.ExpiresAt.After(now) {
+			delete(s.tokens, hash)
+		}
+	}
+	s.tokens[token.Hash] = *token
+	return nil
+}
+
+func (s *MemoryRefreshTokenStore) Find(hash string) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[hash]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	return &token, nil
+}
+
+func (s *MemoryRefreshTokenStore) MarkUsed(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[hash]
+	if !ok {
+		return ErrRefreshTokenNotFound
+	}
+	if token.Used {
+		return ErrRefreshTokenUsed
+	}
+	token.Used = true
+	s.tokens[hash] = token
+	return nil
+}
+
+func (s *MemoryRefreshTokenStore) RevokeFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, token := range s.tokens {
+		if token.FamilyID == familyID {
+			token.Revoked = true
+			s.tokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func (s *MemoryRefreshTokenStore) RevokeUser(userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, token := range s.tokens {
+		if token.UserID == userID {
+			token.Revoked = true
+			s.tokens[hash] = token
+		}
+	}
+	return nil
+}
+
+// MemoryLoginAttemptStore is an in-memory LoginAttemptStore, stale attempts are pruned on Reserve,
+// once every as many calls as there are keys, so that pruning costs a constant time per call on average.
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempts
+	reserves int
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		attempts: map[string]LoginAttempts{},
+	}
+}
+
+func (s *MemoryLoginAttemptStore) Get(key string) (*LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := s.attempts[key]
+	return &attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) Reserve(key string, t time.Time, window time.Duration) (*LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.attempts[key]
+	if s.reserves++; s.reserves > len(s.attempts) {
+		s.reserves = 0
+		for k, a := range s.attempts {
+			if t.Sub(a.LastFailure) >= window {
+				delete(s.attempts, k)
+			}
+		}
+	}
+	attempts := previous
+	if t.Sub(previous.LastFailure) >= window {
+		attempts = LoginAttempts{}
+	}
+	attempts.Failures++
+	attempts.LastFailure = t
+	s.attempts[key] = attempts
+	return &previous, nil
+}
+
+func (s *MemoryLoginAttemptStore) Release(key string, t time.Time, previous time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts, ok := s.attempts[key]
+	if !ok {
+		return nil
+	}
+	attempts.Failures--
+	if attempts.Failures <= 0 {
+		delete(s.attempts, key)
+		return nil
+	}
+	if attempts.LastFailure.Equal(t) {
+		attempts.LastFailure = previous
+	}
+	s.attempts[key] = attempts
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
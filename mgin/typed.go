@@ -0,0 +1,112 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/kacifer/mc/mjwt"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+type errorStatus struct {
+	err    error
+	status int
+}
+
+var errorStatusesMu sync.RWMutex
+var errorStatuses = []errorStatus{
+	{ErrUserIDNotFound, http.StatusNotFound},
+	{ErrUsernameNotFound, http.StatusNotFound},
+	{ErrRefreshTokenNotFound, http.StatusUnauthorized},
+	{ErrRefreshTokenUsed, http.StatusUnauthorized},
+	{mjwt.ErrRevocationDisabled, http.StatusNotImplemented},
+}
+
+// RegisterErrorStatus sets the http status Typed handlers respond with when they return err,
+// errors are matched with errors.Is, the latest registration wins.
+func RegisterErrorStatus(err error, status int) {
+	errorStatusesMu.Lock()
+	defer errorStatusesMu.Unlock()
+	errorStatuses = append(errorStatuses, errorStatus{err, status})
+}
+
+// ErrorStatus returns the status registered for err, ok is false if there is none.
+func ErrorStatus(err error) (status int, ok bool) {
+	errorStatusesMu.RLock()
+	defer errorStatusesMu.RUnlock()
+	for i := len(errorStatuses) - 1; i >= 0; i-- {
+		if errors.Is(err, errorStatuses[i].err) {
+			return errorStatuses[i].status, true
+		}
+	}
+	return 0, false
+}
+
+// Typed adapts f into a HandlerFunc, the request is bound into Req and the response is written as JSON.
+//
+// Req is bound from the JSON body, then the query (form tags) and then the path params (uri tags),
+// and validated once all of them are bound. A returned *E is written with E.Status, an error registered
+// with RegisterErrorStatus is written with its status, any other error is an internal server error.
+func Typed[Req any, Resp any](f func(*Context, Req) (Resp, error)) HandlerFunc {
+	return func(c *Context) {
+		var req Req
+		if !c.MustBindRequest(&req) {
+			return
+		}
+		resp, err := f(c, req)
+		if err != nil {
+			c.AbortAndWriteHandlerError(err)
+			return
+		}
+		if c.IsAborted() || c.Writer.Written() {
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// MustBindRequest binds the JSON body, the query and the path params into obj, and validates it.
+// The body is decoded by the json tags, fields bound from the query or the path only must be tagged json:"-".
+func (c *Context) MustBindRequest(obj any) (ok bool) {
+	if c.Request.Body != nil && c.Request.ContentLength != 0 {
+		if err := json.NewDecoder(c.Request.Body).Decode(obj); err != nil && err != io.EOF {
+			c.AbortAndWriteError(http.StatusBadRequest, errors.Wrap(err, "JSON decode error"))
+			return false
+		}
+	}
+	if err := binding.MapFormWithTag(obj, c.Request.URL.Query(), "form"); err != nil {
+		c.AbortAndWriteError(http.StatusBadRequest, errors.Wrap(err, "query decode error"))
+		return false
+	}
+	params := map[string][]string{}
+	for _, param := range c.Params {
+		params[param.Key] = []string{param.Value}
+	}
+	if err := binding.MapFormWithTag(obj, params, "uri"); err != nil {
+		c.AbortAndWriteError(http.StatusBadRequest, errors.Wrap(err, "path params decode error"))
+		return false
+	}
+	if binding.Validator != nil {
+		if err := binding.Validator.ValidateStruct(obj); err != nil {
+			c.abortAndWriteBindError(err, obj, "validation error")
+			return false
+		}
+	}
+	return true
+}
+
+// AbortAndWriteHandlerError aborts the context with an error returned by a handler, see Typed.
+func (c *Context) AbortAndWriteHandlerError(err error) {
+	var e *E
+	if errors.As(err, &e) {
+		c.AbortAndWriteError(e.Status(), e)
+		return
+	}
+	if status, ok := ErrorStatus(err); ok {
+		c.AbortAndWriteError(status, err)
+		return
+	}
+	c.AbortAndWriteInternalServerError(err)
+}
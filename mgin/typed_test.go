@@ -0,0 +1,62 @@
+package mgin
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+var errTestConflict = errors.New("conflict")
+
+func TestTyped(t *testing.T) {
+	assertions := require.New(t)
+
+	RegisterErrorStatus(errTestConflict, http.StatusConflict)
+
+	type Request struct {
+		ID    uint   `uri:"id" binding:"required"`
+		Page  int    `form:"page"`
+		Title string `json:"title" binding:"required"`
+	}
+	type Response struct {
+		ID    uint   `json:"id"`
+		Page  int    `json:"page"`
+		Title string `json:"title"`
+	}
+
+	r := New()
+	r.PUT("/posts/:id", Typed(func(c *Context, req Request) (*Response, error) {
+		switch req.Title {
+		case "conflict":
+			return nil, errors.Wrap(errTestConflict, "update post error")
+		case "forbidden":
+			return nil, &E{Code: 40301, Message: "forbidden"}
+		case "internal":
+			return nil, errors.New("database down")
+		}
+		return &Response{ID: req.ID, Page: req.Page, Title: req.Title}, nil
+	}))
+
+	recorder := serveJSON(r, http.MethodPut, "/posts/3?page=2", map[string]any{"title": "hello"}, nil)
+	assertions.Equal(http.StatusOK, recorder.Code)
+	assertions.JSONEq(`{"id":3,"page":2,"title":"hello"}`, recorder.Body.String())
+
+	for _, tt := range []struct {
+		body   any
+		status int
+	}{
+		{map[string]any{}, http.StatusUnprocessableEntity},
+		{"not an object", http.StatusBadRequest},
+		{map[string]any{"title": "conflict"}, http.StatusConflict},
+		{map[string]any{"title": "forbidden"}, http.StatusForbidden},
+		{map[string]any{"title": "internal"}, http.StatusInternalServerError},
+	} {
+		recorder := serveJSON(r, http.MethodPut, "/posts/3", tt.body, nil)
+		assertions.Equal(tt.status, recorder.Code, tt.body)
+	}
+
+	status, ok := ErrorStatus(ErrUserIDNotFound)
+	assertions.True(ok)
+	assertions.Equal(http.StatusNotFound, status)
+}
@@ -0,0 +1,135 @@
+package mgin
+
+import (
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var validationMessagesMu sync.RWMutex
+
+// validationMessages are the messages of failed validation tags, {field} is replaced by the field name
+// and {param} by the tag parameter, e.g. 3 for min=3.
+var validationMessages = map[string]string{
+	"":         "{field} is invalid",
+	"required": "{field} is required",
+	"email":    "{field} must be a valid email address",
+	"url":      "{field} must be a valid URL",
+	"uuid":     "{field} must be a valid UUID",
+	"numeric":  "{field} must be numeric",
+	"alphanum": "{field} must contain only letters and digits",
+	"len":      "{field} must have a length of {param}",
+	"min":      "{field} must be at least {param}",
+	"max":      "{field} must be at most {param}",
+	"gt":       "{field} must be greater than {param}",
+	"gte":      "{field} must be at least {param}",
+	"lt":       "{field} must be less than {param}",
+	"lte":      "{field} must be at most {param}",
+	"oneof":    "{field} must be one of {param}",
+	"eqfield":  "{field} must be equal to {param}",
+}
+
+// RegisterValidationMessage sets the message of a validation tag, see validationMessages for the placeholders.
+func RegisterValidationMessage(tag string, message string) {
+	validationMessagesMu.Lock()
+	defer validationMessagesMu.Unlock()
+	validationMessages[tag] = message
+}
+
+// RegisterValidation adds a custom validation tag to the binding validator, with the message of its failures.
+func RegisterValidation(tag string, fn validator.Func, message string) error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("binding validator is not a go-playground validator")
+	}
+	if err := v.RegisterValidation(tag, fn); err != nil {
+		return err
+	}
+	RegisterValidationMessage(tag, message)
+	return nil
+}
+
+// ValidationErrorDetails translates validator errors of obj into details keyed by the field names
+// of the request, json, form or uri tags, nested fields are joined with dots, e.g. "items[0].name".
+// The values are Msg, localized with the key KeyValidationPrefix followed by the tag.
+func ValidationErrorDetails(err error, obj any) (ErrorDetails, bool) {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil, false
+	}
+	validationMessagesMu.RLock()
+	defer validationMessagesMu.RUnlock()
+	details := ErrorDetails{}
+	for _, fieldError := range validationErrors {
+		field := fieldPath(reflect.TypeOf(obj), fieldError.StructNamespace())
+		if _, ok := details[field]; ok {
+			continue
+		}
+		tag := fieldError.Tag()
+		message, ok := validationMessages[tag]
+		if !ok {
+			tag, message = "", validationMessages[""]
+		}
+		details[field] = Msg{
+			Key:     KeyValidationPrefix + tag,
+			Default: message,
+			Params:  map[string]any{"field": field, "param": fieldError.Param()},
+		}
+	}
+	return details, true
+}
+
+// fieldPath converts a struct namespace like "Data.Items[0].Name" into request field names.
+func fieldPath(t reflect.Type, structNamespace string) string {
+	parts := strings.Split(structNamespace, ".")
+	if len(parts) > 1 {
+		// the first part is the name of the struct itself
+		parts = parts[1:]
+	}
+	var names []string
+	for _, part := range parts {
+		fieldName, index, _ := strings.Cut(part, "[")
+		if index != "" {
+			index = "[" + index
+		}
+		name := fieldName
+		t = indirect(t)
+		if t != nil && t.Kind() == reflect.Struct {
+			if field, ok := t.FieldByName(fieldName); ok {
+				name = requestFieldName(field)
+				t = field.Type
+			} else {
+				t = nil
+			}
+		}
+		for i := strings.Count(index, "["); i > 0 && t != nil; i-- {
+			if t = indirect(t); t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+				t = t.Elem()
+			}
+		}
+		names = append(names, name+index)
+	}
+	return strings.Join(names, ".")
+}
+
+func requestFieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form", "uri"} {
+		if name := tagName(field, tag); name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// abortAndWriteBindError writes validation errors of obj as invalid input details, and other errors as bad requests.
+func (c *Context) abortAndWriteBindError(err error, obj any, message string) {
+	if details, ok := ValidationErrorDetails(err, obj); ok {
+		c.AbortAndWriteInvalidInputDetails(details)
+		return
+	}
+	c.AbortAndWriteError(http.StatusBadRequest, errors.Wrap(err, message))
+}
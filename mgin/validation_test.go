@@ -0,0 +1,67 @@
+package mgin
+
+import (
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidationErrorDetails(t *testing.T) {
+	assertions := require.New(t)
+
+	assertions.Nil(RegisterValidation("lowercase_slug", func(fl validator.FieldLevel) bool {
+		return strings.ToLower(fl.Field().String()) == fl.Field().String()
+	}, "{field} must be lower case"))
+
+	type Item struct {
+		Name string `json:"name" binding:"required"`
+	}
+	type Data struct {
+		Username string `json:"username" binding:"required"`
+		Email    string `json:"email" binding:"omitempty,email"`
+		Age      int    `json:"age" binding:"gte=18"`
+		Slug     string `json:"slug" binding:"lowercase_slug"`
+		Items    []Item `json:"items" binding:"dive"`
+	}
+
+	r := New()
+	r.POST("/users", func(c *Context) {
+		var data Data
+		if !c.MustBindJSON(&data) {
+			return
+		}
+		c.JSON(200, "OK")
+	})
+
+	recorder := serveJSON(r, http.MethodPost, "/users", map[string]any{
+		"email": "not an email",
+		"age":   12,
+		"slug":  "Upper",
+		"items": []any{map[string]any{}},
+	}, nil)
+	assertions.Equal(http.StatusUnprocessableEntity, recorder.Code)
+	var e E
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+	assertions.Equal(http.StatusUnprocessableEntity, e.Code)
+	assertions.Equal(ErrorDetails{
+		"username":      "username is required",
+		"email":         "email must be a valid email address",
+		"age":           "age must be at least 18",
+		"slug":          "slug must be lower case",
+		"items[0].name": "items[0].name is required",
+	}, e.Details)
+
+	// decode errors are still bad requests
+	recorder = serveJSON(r, http.MethodPost, "/users", "not an object", nil)
+	assertions.Equal(http.StatusBadRequest, recorder.Code)
+
+	RegisterValidationMessage("required", "please fill in {field}")
+	defer RegisterValidationMessage("required", "{field} is required")
+	recorder = serveJSON(r, http.MethodPost, "/users", map[string]any{"age": 18}, nil)
+	e = E{}
+	assertions.Nil(json.Unmarshal(recorder.Body.Bytes(), &e))
+	assertions.Equal(ErrorDetails{"username": "please fill in username"}, e.Details)
+}
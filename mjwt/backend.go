@@ -0,0 +1,59 @@
+package mjwt
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+// This file is the only place depending on the underlying JWT library.
+
+// supportsMethod reports whether the library implements the signing method, "none" is never supported.
+func supportsMethod(method SigningMethod) bool {
+	return method != "none" && jwt.GetSigningMethod(method.Alg()) != nil
+}
+
+func signToken(token *Token, key any) (string, error) {
+	if !supportsMethod(token.Method) {
+		return "", errors.Errorf("unsupported signing method %s", token.Method)
+	}
+	t := jwt.NewWithClaims(jwt.GetSigningMethod(token.Method.Alg()), jwt.MapClaims(token.Claims))
+	for k, v := range token.Header {
+		t.Header[k] = v
+	}
+	return t.SignedString(key)
+}
+
+// parseToken decodes the token and verifies its signature with the key returned by keyFunc,
+// errors are either ErrTokenMalformed or ErrSignatureInvalid. Claims are not validated.
+func parseToken(tokenString string, keyFunc func(*Token) (any, error)) (*Token, error) {
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	t, err := parser.Parse(tokenString, func(t *jwt.Token) (any, error) {
+		return keyFunc(fromLibraryToken(t))
+	})
+	var token *Token
+	if t != nil {
+		token = fromLibraryToken(t)
+	}
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenMalformed) {
+			return token, &TokenError{Kind: ErrTokenMalformed, Cause: err}
+		}
+		return token, &TokenError{Kind: ErrSignatureInvalid, Cause: err}
+	}
+	return token, nil
+}
+
+func fromLibraryToken(t *jwt.Token) *Token {
+	token := &Token{
+		Raw:    t.Raw,
+		Header: t.Header,
+		Valid:  t.Valid,
+	}
+	if t.Method != nil {
+		token.Method = SigningMethod(t.Method.Alg())
+	}
+	if claims, ok := t.Claims.(jwt.MapClaims); ok {
+		token.Claims = Claims(claims)
+	}
+	return token
+}
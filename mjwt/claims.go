@@ -0,0 +1,202 @@
+package mjwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RegisteredClaims are the claims defined by RFC 7519, embed it in custom claims structs.
+type RegisteredClaims struct {
+	Issuer    string       `json:"iss,omitempty"`
+	Subject   string       `json:"sub,omitempty"`
+	Audience  Audience     `json:"aud,omitempty"`
+	ExpiresAt *NumericDate `json:"exp,omitempty"`
+	NotBefore *NumericDate `json:"nbf,omitempty"`
+	IssuedAt  *NumericDate `json:"iat,omitempty"`
+	ID        string       `json:"jti,omitempty"`
+}
+
+// NumericDate is encoded as the number of seconds since the epoch, as RFC 7519 requires,
+// RFC 3339 strings written by earlier versions are accepted when decoding.
+type NumericDate struct {
+	time.Time
+}
+
+func NewNumericDate(t time.Time) *NumericDate {
+	return &NumericDate{t.Truncate(time.Second)}
+}
+
+func (d NumericDate) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(d.Unix(), 10)), nil
+}
+
+func (d *NumericDate) UnmarshalJSON(data []byte) error {
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return err
+	}
+	t, ok := claimTime(Claims{"t": v}, "t")
+	if !ok {
+		return errors.Errorf("invalid date %s", data)
+	}
+	d.Time = t
+	return nil
+}
+
+// Audience is the aud claim, it is a single string or an array of strings in JSON.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = multiple
+	return nil
+}
+
+// Contains reports whether aud is one of the audiences.
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+const RolesKey = "roles"
+
+// TypeKey is the typ claim, it is set on tokens which are not access tokens.
+const TypeKey = "typ"
+
+// TypeMFAPending is the typ of the tokens issued between the password and the second factor of a login,
+// they must not be accepted as access tokens, UserID rejects them.
+const TypeMFAPending = "mfa_pending"
+
+var ErrTokenTypeInvalid = errors.New("invalid token type")
+
+const ScopeKey = "scope"
+
+// Decode decodes the claims into out, a pointer to a struct or map.
+func (c Claims) Decode(out any) error {
+	return fromMapClaims(c, out)
+}
+
+// UserID returns the id claim, the ID of the user the token was issued to.
+func (c Claims) UserID() (uint, error) {
+	if typ, _ := c[TypeKey].(string); typ == TypeMFAPending {
+		return 0, &ClaimError{Claim: TypeKey, Err: ErrTokenTypeInvalid}
+	}
+	switch v := c[IDKey].(type) {
+	case float64:
+		return uint(v), nil
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil || n < 0 {
+			return 0, errors.Errorf("invalid uid found")
+		}
+		return uint(n), nil
+	case uint:
+		return v, nil
+	default:
+		return 0, errors.Errorf("invalid uid found")
+	}
+}
+
+// Roles returns the roles claim, an array of strings.
+func (c Claims) Roles() []string {
+	return claimStrings(c[RolesKey])
+}
+
+// Scopes returns the scope claim, a space-delimited string as in RFC 8693, an array of strings is accepted too.
+func (c Claims) Scopes() []string {
+	if scope, ok := c[ScopeKey].(string); ok {
+		return strings.Fields(scope)
+	}
+	return claimStrings(c[ScopeKey])
+}
+
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		var values []string
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+type IDClaims struct {
+	ID uint `json:"id"`
+}
+
+type NameClaims struct {
+	Name string `json:"name"`
+}
+
+// toMapClaims converts a struct or map into Claims via its JSON encoding.
+func toMapClaims(claims any) (Claims, error) {
+	if mapClaims, ok := claims.(Claims); ok {
+		return mapClaims, nil
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode claims error")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	mapClaims := Claims{}
+	if err := decoder.Decode(&mapClaims); err != nil {
+		return nil, errors.Wrap(err, "claims must be encoded as a JSON object")
+	}
+	return mapClaims, nil
+}
+
+// fromMapClaims decodes Claims into out, a pointer to a struct or map.
+func fromMapClaims(claims Claims, out any) error {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return errors.Wrap(err, "encode claims error")
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return errors.Wrap(err, "decode claims error")
+	}
+	return nil
+}
+
+// ParseClaims validates the signed string and decodes its claims into a new T.
+func ParseClaims[T any](e ClaimsEngine, tokenString string) (*T, error) {
+	var claims T
+	if err := e.ExtractClaimsFromSignedString(tokenString, &claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
@@ -0,0 +1,31 @@
+package mjwt
+
+import "github.com/pkg/errors"
+
+// Errors returned by Parse, Validate and their variants, they can be checked with errors.Is,
+// claim validation errors are listed with ClaimError.
+var ErrAuthHeaderMissing = errors.New("auth header missing")
+var ErrAuthHeaderMalformed = errors.New("malformed auth header")
+var ErrTokenMalformed = errors.New("malformed token")
+var ErrSignatureInvalid = errors.New("invalid signature")
+
+// TokenError classifies an error with Kind, which errors.Is matches, while errors.Unwrap returns the cause.
+type TokenError struct {
+	Kind  error
+	Cause error
+}
+
+func (e *TokenError) Error() string {
+	if e.Cause == nil {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Cause.Error()
+}
+
+func (e *TokenError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Cause
+}
@@ -0,0 +1,390 @@
+package mjwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JSONWebKey is the public part of a Key, as defined in RFC 7517.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKSProvider is implemented by engines which can publish their public keys.
+type JWKSProvider interface {
+	JWKS() *JSONWebKeySet
+}
+
+// KeySet looks up verification keys by kid.
+type KeySet interface {
+	Get(kid string) (*Key, error)
+}
+
+var b64 = base64.RawURLEncoding
+
+// NewJSONWebKey encodes the public part of an asymmetric key, HMAC keys return ErrUnsupportedKey.
+func NewJSONWebKey(key *Key) (JSONWebKey, error) {
+	if key.isHMAC() {
+		return JSONWebKey{}, ErrUnsupportedKey
+	}
+	publicKey, err := key.verifyKey()
+	if err != nil {
+		return JSONWebKey{}, err
+	}
+	jwk := JSONWebKey{
+		Kid: key.ID,
+		Use: "sig",
+		Alg: key.signingMethod().Alg(),
+	}
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64.EncodeToString(k.N.Bytes())
+		jwk.E = b64.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = k.Curve.Params().Name
+		jwk.X = b64.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64.EncodeToString(k)
+	default:
+		return JSONWebKey{}, ErrUnsupportedKey
+	}
+	return jwk, nil
+}
+
+// Key decodes the JSON web key into a verify-only Key.
+func (k JSONWebKey) Key() (*Key, error) {
+	var publicKey any
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid n")
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid e")
+		}
+		publicKey = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Wrapf(ErrUnsupportedKey, "curve %s", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid x")
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid y")
+		}
+		ecdsaKey := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(ecdsaKey.X, ecdsaKey.Y) {
+			return nil, errors.New("point is not on curve")
+		}
+		publicKey = ecdsaKey
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errors.Wrapf(ErrUnsupportedKey, "curve %s", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid x")
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		publicKey = ed25519.PublicKey(x)
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedKey, "kty %s", k.Kty)
+	}
+	key, err := NewPublicKey(k.Kid, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if k.Alg != "" {
+		method := SigningMethod(k.Alg)
+		if !supportsMethod(method) {
+			return nil, errors.Errorf("unknown alg %s", k.Alg)
+		}
+		if !methodMatchesKey(method, key.Method) {
+			return nil, errors.Errorf("alg %s does not match kty %s", k.Alg, k.Kty)
+		}
+		key.Method = method
+	}
+	return key, nil
+}
+
+// methodMatchesKey reports whether the alg of a JSON web key can be used with its key,
+// RSA keys accept the RS and PS methods, other keys only the method of their curve, HMAC is never accepted.
+func methodMatchesKey(method SigningMethod, keyMethod SigningMethod) bool {
+	if method.IsHMAC() {
+		return false
+	}
+	if keyMethod == SigningMethodRS256 {
+		return strings.HasPrefix(method.Alg(), "RS") || strings.HasPrefix(method.Alg(), "PS")
+	}
+	return method == keyMethod
+}
+
+// JWKS returns the public keys of the keyring, HMAC keys are skipped.
+func (r *Keyring) JWKS() *JSONWebKeySet {
+	jwks := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range r.Keys() {
+		if jwk, err := NewJSONWebKey(key); err == nil {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
+
+// JWKS returns the public keys used by the engine, it is empty for HMAC engines.
+func (e *EngineImpl) JWKS() *JSONWebKeySet {
+	if e.Keyring != nil {
+		return e.Keyring.JWKS()
+	}
+	jwks := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	if key, err := e.currentKey(); err == nil {
+		if jwk, err := NewJSONWebKey(key); err == nil {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
+
+// ParseJWKS decodes a JWK set into verify-only keys indexed by kid, keys of unsupported types are skipped.
+// A key without kid is kept only if it is the single key of the set, and of keys sharing a kid only the first,
+// so that a kid always selects one key.
+func ParseJWKS(data []byte) (map[string]*Key, error) {
+	var jwks JSONWebKeySet
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, errors.Wrap(err, "decode JWKS error")
+	}
+	var parsed []*Key
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.Key()
+		if err != nil {
+			continue
+		}
+		parsed = append(parsed, key)
+	}
+	keys := map[string]*Key{}
+	for _, key := range parsed {
+		if key.ID == "" && len(parsed) > 1 {
+			continue
+		}
+		if _, ok := keys[key.ID]; !ok {
+			keys[key.ID] = key
+		}
+	}
+	return keys, nil
+}
+
+const DefaultJWKSCacheTTL = time.Hour
+
+const DefaultJWKSMinRefreshInterval = time.Minute
+
+const DefaultJWKSTimeout = 10 * time.Second
+
+// JWKSKeySet loads verification keys from a JWKS document, Source is either an HTTP(S) URL or a file path.
+// Keys are cached for CacheTTL, and refreshed when an unknown kid is seen,
+// at most once per MinRefreshInterval, failed loads included. Concurrent lookups share a single load,
+// which does not block lookups of keys already loaded.
+type JWKSKeySet struct {
+	Source string
+
+	// Client defaults to a client with a timeout of DefaultJWKSTimeout.
+	Client *http.Client
+
+	CacheTTL time.Duration
+
+	MinRefreshInterval time.Duration
+
+	NowFunc func() time.Time
+
+	mu          sync.Mutex
+	keys        map[string]*Key
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	lastErr     error
+	refreshing  chan struct{}
+}
+
+func NewJWKSKeySet(source string) *JWKSKeySet {
+	return &JWKSKeySet{
+		Source:             source,
+		Client:             &http.Client{Timeout: DefaultJWKSTimeout},
+		CacheTTL:           DefaultJWKSCacheTTL,
+		MinRefreshInterval: DefaultJWKSMinRefreshInterval,
+		NowFunc:            time.Now,
+	}
+}
+
+// Get returns the key with kid, if kid is empty and the set holds a single key, that key is returned.
+// When reloading fails, the previously loaded keys keep being used.
+func (s *JWKSKeySet) Get(kid string) (*Key, error) {
+	now := s.NowFunc()
+	s.mu.Lock()
+	stale := s.keys == nil || now.Sub(s.fetchedAt) >= s.CacheTTL
+	s.mu.Unlock()
+	if stale {
+		if err := s.refresh(now, false); err != nil && !s.loaded() {
+			return nil, err
+		}
+	}
+	key, ok := s.find(kid)
+	if !ok {
+		if err := s.refresh(now, false); err != nil {
+			return nil, err
+		}
+		key, ok = s.find(kid)
+	}
+	if !ok {
+		return nil, errors.Wrapf(ErrKeyNotFound, "kid %s", kid)
+	}
+	return key, nil
+}
+
+// Refresh reloads the keys from Source.
+func (s *JWKSKeySet) Refresh() error {
+	return s.refresh(s.NowFunc(), true)
+}
+
+func (s *JWKSKeySet) loaded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys != nil
+}
+
+func (s *JWKSKeySet) find(kid string) (*Key, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// refresh loads the keys unless they have been attempted to within MinRefreshInterval, and unless force,
+// in which case the error of that attempt is returned. A refresh in progress is waited for instead of repeated.
+func (s *JWKSKeySet) refresh(now time.Time, force bool) error {
+	s.mu.Lock()
+	if done := s.refreshing; done != nil {
+		s.mu.Unlock()
+		<-done
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.lastErr
+	}
+	if !force && !s.attemptedAt.IsZero() && now.Sub(s.attemptedAt) < s.MinRefreshInterval {
+		defer s.mu.Unlock()
+		return s.lastErr
+	}
+	done := make(chan struct{})
+	s.refreshing = done
+	s.attemptedAt = now
+	s.mu.Unlock()
+
+	keys, err := s.fetch()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.keys = keys
+		s.fetchedAt = now
+	}
+	s.lastErr = err
+	s.refreshing = nil
+	close(done)
+	return err
+}
+
+func (s *JWKSKeySet) fetch() (map[string]*Key, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, errors.Wrap(err, "load JWKS error")
+	}
+	return ParseJWKS(data)
+}
+
+func (s *JWKSKeySet) load() ([]byte, error) {
+	if !strings.HasPrefix(s.Source, "http://") && !strings.HasPrefix(s.Source, "https://") {
+		return os.ReadFile(s.Source)
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultJWKSTimeout}
+	}
+	resp, err := client.Get(s.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// NewJWKSVerifier creates a verify-only engine whose keys are loaded from a JWKS URL or file.
+func NewJWKSVerifier(source string, lease time.Duration) *EngineImpl {
+	return &EngineImpl{
+		KeySet:  NewJWKSKeySet(source),
+		Lease:   lease,
+		NowFunc: time.Now,
+	}
+}
@@ -0,0 +1,277 @@
+package mjwt
+
+import (
+	"crypto"
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const KeyIDHeader = "kid"
+
+var ErrKeyNotFound = errors.New("key not found")
+var ErrDuplicateKeyID = errors.New("duplicate key id")
+var ErrNoActiveKey = errors.New("no active key")
+var ErrRetireActiveKey = errors.New("the active key can not be retired")
+
+// Key is a signing or verification key, ID is written to the kid header of tokens it signs.
+type Key struct {
+	ID string
+
+	// Method is the signing method, defaults to the method of PrivateKey or PublicKey, or HS256 without them.
+	Method SigningMethod
+
+	// Secret is the HMAC secret, used when Method is an HMAC method, it must not be empty.
+	Secret []byte
+
+	// PrivateKey signs tokens for asymmetric methods, leave it nil for a verify-only key.
+	PrivateKey crypto.Signer
+
+	// PublicKey verifies tokens for asymmetric methods, defaults to the public part of PrivateKey.
+	PublicKey crypto.PublicKey
+
+	// DeactivatedAt is when the key stopped being the active key of a Keyring,
+	// it is zero while the key is active or if it has never been active.
+	DeactivatedAt time.Time
+}
+
+// NewHMACKey creates a HS256 key.
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		ID:     id,
+		Method: SigningMethodHS256,
+		Secret: secret,
+	}
+}
+
+// NewPrivateKey creates an asymmetric key which can sign and verify, the method is chosen by MethodForKey.
+func NewPrivateKey(id string, privateKey crypto.Signer) (*Key, error) {
+	method, err := MethodForKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		ID:         id,
+		Method:     method,
+		PrivateKey: privateKey,
+	}, nil
+}
+
+// NewPublicKey creates an asymmetric verify-only key, the method is chosen by MethodForKey.
+func NewPublicKey(id string, publicKey crypto.PublicKey) (*Key, error) {
+	method, err := MethodForKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		ID:        id,
+		Method:    method,
+		PublicKey: publicKey,
+	}, nil
+}
+
+func (k *Key) signingMethod() SigningMethod {
+	if k.Method != "" {
+		return k.Method
+	}
+	if k.PrivateKey != nil {
+		if method, err := MethodForKey(k.PrivateKey); err == nil {
+			return method
+		}
+	}
+	if k.PublicKey != nil {
+		if method, err := MethodForKey(k.PublicKey); err == nil {
+			return method
+		}
+	}
+	return SigningMethodHS256
+}
+
+// hmacSecret returns the HMAC secret, an empty secret would let anyone sign tokens.
+func (k *Key) hmacSecret() ([]byte, error) {
+	if len(k.Secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+	return k.Secret, nil
+}
+
+func (k *Key) isHMAC() bool {
+	return k.signingMethod().IsHMAC()
+}
+
+// acceptsMethod accepts any HMAC method for HMAC keys, asymmetric keys require the exact algorithm.
+func (k *Key) acceptsMethod(method SigningMethod) bool {
+	if k.isHMAC() {
+		return method.IsHMAC()
+	}
+	return method == k.signingMethod()
+}
+
+func (k *Key) signingKey() (any, error) {
+	if k.isHMAC() {
+		return k.hmacSecret()
+	}
+	if k.PrivateKey == nil {
+		return nil, ErrVerifyOnly
+	}
+	return k.PrivateKey, nil
+}
+
+func (k *Key) verifyKey() (any, error) {
+	if k.isHMAC() {
+		return k.hmacSecret()
+	}
+	if k.PublicKey != nil {
+		return k.PublicKey, nil
+	}
+	if k.PrivateKey != nil {
+		return k.PrivateKey.Public(), nil
+	}
+	return nil, errors.New("no public key")
+}
+
+// apply sets the signing method and the kid header of the token.
+func (k *Key) apply(token *Token) {
+	token.Method = k.signingMethod()
+	token.Header["alg"] = token.Method.Alg()
+	if k.ID != "" {
+		token.Header[KeyIDHeader] = k.ID
+	} else {
+		delete(token.Header, KeyIDHeader)
+	}
+}
+
+// Keyring holds several keys, the active one signs new tokens,
+// all of them are accepted for verification until they are retired.
+type Keyring struct {
+	mu       sync.RWMutex
+	keys     map[string]*Key
+	activeID string
+
+	NowFunc func() time.Time
+}
+
+// NewKeyring creates a keyring with the given keys, the first one becomes the active key.
+func NewKeyring(keys ...*Key) (*Keyring, error) {
+	r := &Keyring{
+		keys:    map[string]*Key{},
+		NowFunc: time.Now,
+	}
+	for _, key := range keys {
+		if err := r.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	if len(keys) > 0 {
+		if err := r.SetActive(keys[0].ID); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Add adds a key for verification, it does not change the active key.
+func (r *Keyring) Add(key *Key) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.keys[key.ID]; ok {
+		return errors.Wrapf(ErrDuplicateKeyID, "kid %s", key.ID)
+	}
+	r.keys[key.ID] = key
+	return nil
+}
+
+// SetActive makes the key with id the signing key, the previous active key stays valid for verification.
+func (r *Keyring) SetActive(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, ok := r.keys[id]
+	if !ok {
+		return errors.Wrapf(ErrKeyNotFound, "kid %s", id)
+	}
+	if id == r.activeID {
+		return nil
+	}
+	if previous, ok := r.keys[r.activeID]; ok {
+		previous.DeactivatedAt = r.NowFunc()
+	}
+	key.DeactivatedAt = time.Time{}
+	r.activeID = id
+	return nil
+}
+
+// Rotate adds the key and makes it the active key.
+func (r *Keyring) Rotate(key *Key) error {
+	if err := r.Add(key); err != nil {
+		return err
+	}
+	return r.SetActive(key.ID)
+}
+
+// Active returns the key used for signing.
+func (r *Keyring) Active() (*Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[r.activeID]
+	if !ok {
+		return nil, ErrNoActiveKey
+	}
+	return key, nil
+}
+
+// Get returns the key with id.
+func (r *Keyring) Get(id string) (*Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrKeyNotFound, "kid %s", id)
+	}
+	return key, nil
+}
+
+// Keys returns all keys ordered by ID.
+func (r *Keyring) Keys() []*Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]*Key, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// Retire removes the key, tokens signed by it will no longer be accepted.
+func (r *Keyring) Retire(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id == r.activeID {
+		return ErrRetireActiveKey
+	}
+	if _, ok := r.keys[id]; !ok {
+		return errors.Wrapf(ErrKeyNotFound, "kid %s", id)
+	}
+	delete(r.keys, id)
+	return nil
+}
+
+// RetireDeactivatedBefore retires every key deactivated at or before t, and returns their IDs.
+// Keys that have never been active are kept.
+func (r *Keyring) RetireDeactivatedBefore(t time.Time) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var retired []string
+	for id, key := range r.keys {
+		if id == r.activeID || key.DeactivatedAt.IsZero() || key.DeactivatedAt.After(t) {
+			continue
+		}
+		delete(r.keys, id)
+		retired = append(retired, id)
+	}
+	sort.Strings(retired)
+	return retired
+}
@@ -0,0 +1,88 @@
+package mjwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/pkg/errors"
+)
+
+var ErrUnsupportedKey = errors.New("unsupported key type")
+
+var ErrEmptySecret = errors.New("HMAC secret is empty")
+
+var ErrVerifyOnly = errors.New("engine has no private key, it can only verify tokens")
+
+// MethodForKey returns the signing method matching a private or public key:
+// RS256 for RSA, ES256/ES384/ES512 for ECDSA (by curve) and EdDSA for Ed25519.
+func MethodForKey(key any) (SigningMethod, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		return methodForCurve(k.Curve)
+	case *ecdsa.PublicKey:
+		return methodForCurve(k.Curve)
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return SigningMethodEdDSA, nil
+	default:
+		return "", ErrUnsupportedKey
+	}
+}
+
+func methodForCurve(curve elliptic.Curve) (SigningMethod, error) {
+	switch curve {
+	case elliptic.P256():
+		return SigningMethodES256, nil
+	case elliptic.P384():
+		return SigningMethodES384, nil
+	case elliptic.P521():
+		return SigningMethodES512, nil
+	default:
+		return "", ErrUnsupportedKey
+	}
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) encoded private key.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM data")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, ErrUnsupportedKey
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// ParsePublicKeyPEM parses a PKIX or PKCS#1 (RSA) encoded public key, or takes the public key of a certificate.
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM data")
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+	return nil, ErrUnsupportedKey
+}
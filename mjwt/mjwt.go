@@ -1,9 +1,11 @@
 package mjwt
 
 import (
+	"crypto"
+	"encoding/json"
 	"fmt"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
+	"strings"
 	"time"
 )
 
@@ -14,86 +16,330 @@ const IDKey = "id"
 const NameKey = "name"
 
 type Engine interface {
-	SignForID(id uint) (token *jwt.Token)
+	SignForID(id uint) (token *Token, err error)
 	SignedStringForID(id uint) (tokenString string, err error)
-	SignForName(name string) (token *jwt.Token)
+	SignForName(name string) (token *Token, err error)
 	SignedStringForName(name string) (tokenString string, err error)
-	Parse(tokenString string) (*jwt.Token, error)
-	Validate(token *jwt.Token) (jwt.MapClaims, error)
-	ValidateSignedString(tokenString string) (*jwt.Token, jwt.MapClaims, error)
-	ValidateHeader(authHeader string) (*jwt.Token, jwt.MapClaims, error)
-	ExtractID(token *jwt.Token) (id uint, err error)
+	Parse(tokenString string) (*Token, error)
+	Validate(token *Token) (Claims, error)
+	ValidateSignedString(tokenString string) (*Token, Claims, error)
+	ValidateHeader(authHeader string) (*Token, Claims, error)
+	ExtractID(token *Token) (id uint, err error)
 	ExtractIDFromSignedString(tokenString string) (id uint, err error)
 	ExtractIDFromHeader(authHeader string) (id uint, err error)
-	ExtractName(token *jwt.Token) (name string, err error)
+	ExtractName(token *Token) (name string, err error)
 	ExtractNameFromSignedString(tokenString string) (name string, err error)
 	ExtractNameFromHeader(authHeader string) (name string, err error)
 }
 
+// ClaimsEngine is implemented by engines which can sign and decode arbitrary claims.
+type ClaimsEngine interface {
+	Engine
+	SignForClaims(claims any) (token *Token, err error)
+	SignedStringForClaims(claims any) (tokenString string, err error)
+	ExtractClaims(token *Token, out any) error
+	ExtractClaimsFromSignedString(tokenString string, out any) error
+	ExtractClaimsFromHeader(authHeader string, out any) error
+}
+
+// Revoker is implemented by engines which can deny tokens before they expire.
+type Revoker interface {
+	Revoke(claims Claims) error
+	RevokeUser(userID uint, before time.Time) error
+}
+
 type EngineImpl struct {
+	// Secret is the HMAC secret, used when Method is an HMAC method, it must not be empty.
 	Secret []byte
 
+	// Method is the signing method, defaults to the method of PrivateKey or PublicKey, or HS256 without them.
+	Method SigningMethod
+
+	// PrivateKey signs tokens for asymmetric methods, leave it nil to build a verify-only engine.
+	PrivateKey crypto.Signer
+
+	// PublicKey verifies tokens for asymmetric methods, defaults to the public part of PrivateKey.
+	PublicKey crypto.PublicKey
+
+	// Keyring supports key rotation, if set, tokens are signed by its active key with a kid header,
+	// and verified by the key their kid refers to. Secret, Method, PrivateKey and PublicKey are ignored.
+	Keyring *Keyring
+
+	// KeySet builds a verify-only engine, such as a JWKSKeySet, tokens are verified by the key their kid refers to.
+	KeySet KeySet
+
+	// Revocations denies tokens before they expire, if set, signed tokens carry a jti claim.
+	Revocations RevocationStore
+
+	// Issuer is written to the iss claim of signed tokens, and validated tokens must carry the same iss.
+	Issuer string
+
+	// Audience is written to the aud claim of signed tokens.
+	Audience []string
+
+	// AcceptedAudiences requires the aud claim of validated tokens to contain at least one of them.
+	AcceptedAudiences []string
+
+	// Leeway tolerates clock skew between nodes when checking exp, nbf and iat.
+	Leeway time.Duration
+
+	// RequiredClaims must be present in validated tokens.
+	RequiredClaims []string
+
+	// SetNotBefore writes the nbf claim to signed tokens.
+	SetNotBefore bool
+
+	// LegacyTimestamps writes iat, exp and nbf as RFC 3339 strings like earlier versions did,
+	// instead of NumericDate seconds, for clients that pin the exact output.
+	LegacyTimestamps bool
+
 	Lease time.Duration
 
 	NowFunc func() time.Time
 }
 
-func (e *EngineImpl) SignMapClaims(claims jwt.MapClaims) (token *jwt.Token) {
-	claims["iat"] = e.NowFunc()
-	claims["exp"] = e.NowFunc().Add(e.Lease)
-	token = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+// SignMapClaims creates a token with the claims, iat and exp are filled in unless they are already set.
+func (e *EngineImpl) SignMapClaims(claims Claims) (token *Token) {
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = e.timestamp(e.NowFunc())
+	}
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = e.timestamp(e.NowFunc().Add(e.Lease))
+	}
+	if _, ok := claims["nbf"]; !ok && e.SetNotBefore {
+		claims["nbf"] = e.timestamp(e.NowFunc())
+	}
+	if _, ok := claims["iss"]; !ok && e.Issuer != "" {
+		claims["iss"] = e.Issuer
+	}
+	if _, ok := claims["aud"]; !ok && len(e.Audience) > 0 {
+		claims["aud"] = Audience(e.Audience)
+	}
+	if _, ok := claims[JTIKey]; !ok && e.Revocations != nil {
+		claims[JTIKey] = newJTI()
+	}
+	token = NewToken(SigningMethodHS256, claims)
+	if key, err := e.currentKey(); err == nil {
+		key.apply(token)
+	}
 
 	// SignForID and get the complete encoded token as a string using the secret
 	return token
 }
 
+func (e *EngineImpl) timestamp(t time.Time) any {
+	if e.LegacyTimestamps {
+		return t
+	}
+	return t.Unix()
+}
+
+// SignedString signs the token with the current key, which is the active key of Keyring if set.
+func (e *EngineImpl) SignedString(token *Token) (tokenString string, err error) {
+	key, err := e.currentKey()
+	if err != nil {
+		return "", err
+	}
+	signingKey, err := key.signingKey()
+	if err != nil {
+		return "", err
+	}
+	key.apply(token)
+	return signToken(token, signingKey)
+}
+
+// SignForClaims creates a token from a struct or map of claims, such as a struct embedding RegisteredClaims.
+func (e *EngineImpl) SignForClaims(claims any) (token *Token, err error) {
+	mapClaims, err := toMapClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	return e.SignMapClaims(mapClaims), nil
+}
+
+func (e *EngineImpl) SignedStringForClaims(claims any) (tokenString string, err error) {
+	token, err := e.SignForClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	return e.SignedString(token)
+}
+
 // SignForID and get the complete encoded token as a string using the secret
-func (e *EngineImpl) SignForID(id uint) (token *jwt.Token) {
-	return e.SignMapClaims(jwt.MapClaims{
-		IDKey: id,
-	})
+func (e *EngineImpl) SignForID(id uint) (token *Token, err error) {
+	return e.SignForClaims(&IDClaims{ID: id})
 }
 
 func (e *EngineImpl) SignedStringForID(id uint) (tokenString string, err error) {
-	return e.SignForID(id).SignedString(e.Secret)
+	return e.SignedStringForClaims(&IDClaims{ID: id})
 }
 
 // SignForName and get the complete encoded token as a string using the secret
-func (e *EngineImpl) SignForName(name string) (token *jwt.Token) {
-	return e.SignMapClaims(jwt.MapClaims{
-		NameKey: name,
-	})
+func (e *EngineImpl) SignForName(name string) (token *Token, err error) {
+	return e.SignForClaims(&NameClaims{Name: name})
 }
 
 func (e *EngineImpl) SignedStringForName(name string) (tokenString string, err error) {
-	return e.SignForName(name).SignedString(e.Secret)
+	return e.SignedStringForClaims(&NameClaims{Name: name})
 }
 
 // Parse do not Validate the token payload
-func (e *EngineImpl) Parse(tokenString string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+func (e *EngineImpl) Parse(tokenString string) (*Token, error) {
+	// claims are checked by Validate, which supports leeway
+	return parseToken(tokenString, func(token *Token) (any, error) {
+		key, err := e.lookupKey(token)
+		if err != nil {
+			return nil, err
+		}
+
 		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if !key.acceptsMethod(token.Method) {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		return e.Secret, nil
+		return key.verifyKey()
 	})
 }
 
-func (e *EngineImpl) Validate(token *jwt.Token) (jwt.MapClaims, error) {
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
+// currentKey returns the active key of Keyring, or the key made of the engine's own fields.
+func (e *EngineImpl) currentKey() (*Key, error) {
+	if e.Keyring != nil {
+		return e.Keyring.Active()
+	}
+	if e.KeySet != nil {
+		return nil, ErrVerifyOnly
+	}
+	return &Key{
+		Method:     e.Method,
+		Secret:     e.Secret,
+		PrivateKey: e.PrivateKey,
+		PublicKey:  e.PublicKey,
+	}, nil
+}
+
+// lookupKey picks the verification key by the kid header, tokens without kid are verified by the current key.
+func (e *EngineImpl) lookupKey(token *Token) (*Key, error) {
+	if e.KeySet != nil {
+		kid, _ := token.Header[KeyIDHeader].(string)
+		return e.KeySet.Get(kid)
+	}
+	if e.Keyring != nil {
+		if kid, ok := token.Header[KeyIDHeader].(string); ok {
+			return e.Keyring.Get(kid)
+		}
+	}
+	return e.currentKey()
+}
+
+// RetireExpiredKeys retires the keys of Keyring which were deactivated more than a Lease ago,
+// all tokens signed by them have expired by now.
+func (e *EngineImpl) RetireExpiredKeys() []string {
+	if e.Keyring == nil {
+		return nil
+	}
+	return e.Keyring.RetireDeactivatedBefore(e.NowFunc().Add(-e.Lease))
+}
+
+func (e *EngineImpl) Validate(token *Token) (Claims, error) {
+	claims := token.Claims
+	if claims == nil {
 		return nil, errors.Errorf("invalid claims")
 	}
 	if !token.Valid {
-		return nil, errors.Errorf("invalid token")
+		return nil, ErrSignatureInvalid
+	}
+	if err := e.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	if err := e.checkRevoked(claims); err != nil {
+		return nil, err
 	}
 	return claims, nil
 }
 
-func (e *EngineImpl) ValidateSignedString(tokenString string) (*jwt.Token, jwt.MapClaims, error) {
+func (e *EngineImpl) checkRevoked(claims Claims) error {
+	if e.Revocations == nil {
+		return nil
+	}
+	if jti, ok := claims[JTIKey].(string); ok {
+		revoked, err := e.Revocations.IsRevoked(jti)
+		if err != nil {
+			return errors.Wrap(err, "check revocation error")
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+	if id, err := e.ExtractIDFromClaims(claims); err == nil {
+		before, err := e.Revocations.UserRevokedBefore(id)
+		if err != nil {
+			return errors.Wrap(err, "check revocation error")
+		}
+		if iat, ok := claimTime(claims, "iat"); ok && iat.Before(before) {
+			return ErrTokenRevoked
+		}
+	}
+	return nil
+}
+
+// Revoke denies the token with the claims until it expires.
+func (e *EngineImpl) Revoke(claims Claims) error {
+	if e.Revocations == nil {
+		return ErrRevocationDisabled
+	}
+	jti, ok := claims[JTIKey].(string)
+	if !ok || jti == "" {
+		return ErrMissingJTI
+	}
+	exp, ok := claimTime(claims, "exp")
+	if !ok {
+		exp = e.NowFunc().Add(e.Lease)
+	}
+	return e.Revocations.Revoke(jti, exp)
+}
+
+// RevokeUser denies every token of the user issued before `before`, it is "log out everywhere".
+// It is truncated to the second like iat, so that tokens issued right after, in the same second, are accepted.
+func (e *EngineImpl) RevokeUser(userID uint, before time.Time) error {
+	if e.Revocations == nil {
+		return ErrRevocationDisabled
+	}
+	before = before.Truncate(time.Second)
+	return e.Revocations.RevokeUser(userID, before, before.Add(e.Lease))
+}
+
+// Now returns the time of NowFunc, which iat, exp and nbf are computed from.
+func (e *EngineImpl) Now() time.Time {
+	return e.NowFunc()
+}
+
+// claimTime reads a time claim, it accepts NumericDate seconds as well as RFC 3339 strings.
+func claimTime(claims Claims, key string) (time.Time, bool) {
+	switch v := claims[key].(type) {
+	case time.Time:
+		return v, true
+	case float64:
+		return time.Unix(0, int64(v*float64(time.Second))), true
+	case int64:
+		return time.Unix(v, 0), true
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(f*float64(time.Second))), true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func (e *EngineImpl) ValidateSignedString(tokenString string) (*Token, Claims, error) {
 	token, err := e.Parse(tokenString)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "parse error")
@@ -105,33 +351,58 @@ func (e *EngineImpl) ValidateSignedString(tokenString string) (*jwt.Token, jwt.M
 	return token, claims, nil
 }
 
-func (e *EngineImpl) ValidateHeader(authHeader string) (*jwt.Token, jwt.MapClaims, error) {
+// ValidateHeader validates the token of an Authorization header, which must use the Bearer scheme.
+func (e *EngineImpl) ValidateHeader(authHeader string) (*Token, Claims, error) {
+	tokenString, err := BearerToken(authHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e.ValidateSignedString(tokenString)
+}
+
+// BearerToken returns the token of an Authorization header, the scheme is case-insensitive as described in RFC 6750.
+func BearerToken(authHeader string) (string, error) {
 	if authHeader == "" {
-		return nil, nil, errors.New("auth header missing")
+		return "", ErrAuthHeaderMissing
 	}
-	if len(authHeader) < 7 {
-		return nil, nil, errors.Errorf("malformed auth header")
+	scheme, tokenString, ok := strings.Cut(authHeader, " ")
+	tokenString = strings.TrimSpace(tokenString)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || tokenString == "" {
+		return "", ErrAuthHeaderMalformed
 	}
-	return e.ValidateSignedString(authHeader[7:])
+	return tokenString, nil
 }
 
-func (e *EngineImpl) ExtractIDFromClaims(claims jwt.MapClaims) (id uint, err error) {
-	switch claims[IDKey].(type) {
-	case float64:
-		id = uint(claims[IDKey].(float64))
-	case uint:
-		id = claims[IDKey].(uint)
-	default:
-		var ok bool
-		id, ok = claims[IDKey].(uint)
-		if !ok {
-			return 0, errors.Errorf("invalid uid found")
-		}
+// ExtractClaims validates the token and decodes its claims into out, a pointer to a struct or map.
+func (e *EngineImpl) ExtractClaims(token *Token, out any) error {
+	claims, err := e.Validate(token)
+	if err != nil {
+		return err
+	}
+	return fromMapClaims(claims, out)
+}
+
+func (e *EngineImpl) ExtractClaimsFromSignedString(tokenString string, out any) error {
+	_, claims, err := e.ValidateSignedString(tokenString)
+	if err != nil {
+		return err
+	}
+	return fromMapClaims(claims, out)
+}
+
+func (e *EngineImpl) ExtractClaimsFromHeader(authHeader string, out any) error {
+	_, claims, err := e.ValidateHeader(authHeader)
+	if err != nil {
+		return err
 	}
-	return id, nil
+	return fromMapClaims(claims, out)
+}
+
+func (e *EngineImpl) ExtractIDFromClaims(claims Claims) (id uint, err error) {
+	return claims.UserID()
 }
 
-func (e *EngineImpl) ExtractID(token *jwt.Token) (id uint, err error) {
+func (e *EngineImpl) ExtractID(token *Token) (id uint, err error) {
 	claims, err := e.Validate(token)
 	if err != nil {
 		return 0, err
@@ -155,7 +426,7 @@ func (e *EngineImpl) ExtractIDFromHeader(authHeader string) (id uint, err error)
 	return e.ExtractIDFromClaims(claims)
 }
 
-func (e *EngineImpl) ExtractNameFromClaims(claims jwt.MapClaims) (name string, err error) {
+func (e *EngineImpl) ExtractNameFromClaims(claims Claims) (name string, err error) {
 	switch claims[NameKey].(type) {
 	case string:
 		name = claims[NameKey].(string)
@@ -169,7 +440,7 @@ func (e *EngineImpl) ExtractNameFromClaims(claims jwt.MapClaims) (name string, e
 	return name, nil
 }
 
-func (e *EngineImpl) ExtractName(token *jwt.Token) (name string, err error) {
+func (e *EngineImpl) ExtractName(token *Token) (name string, err error) {
 	claims, err := e.Validate(token)
 	if err != nil {
 		return "", err
@@ -201,6 +472,44 @@ func NewImpl(secret []byte, lease time.Duration) *EngineImpl {
 	}
 }
 
+// NewImplWithPrivateKey creates an engine which signs and verifies tokens with an RSA, ECDSA or Ed25519 key,
+// the signing method is chosen by MethodForKey.
+func NewImplWithPrivateKey(privateKey crypto.Signer, lease time.Duration) (*EngineImpl, error) {
+	method, err := MethodForKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &EngineImpl{
+		Method:     method,
+		PrivateKey: privateKey,
+		Lease:      lease,
+		NowFunc:    time.Now,
+	}, nil
+}
+
+// NewImplWithPublicKey creates a verify-only engine, signing with it returns ErrVerifyOnly.
+func NewImplWithPublicKey(publicKey crypto.PublicKey, lease time.Duration) (*EngineImpl, error) {
+	method, err := MethodForKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &EngineImpl{
+		Method:    method,
+		PublicKey: publicKey,
+		Lease:     lease,
+		NowFunc:   time.Now,
+	}, nil
+}
+
+// NewImplWithKeyring creates an engine which signs and verifies tokens with the keys of keyring.
+func NewImplWithKeyring(keyring *Keyring, lease time.Duration) *EngineImpl {
+	return &EngineImpl{
+		Keyring: keyring,
+		Lease:   lease,
+		NowFunc: time.Now,
+	}
+}
+
 func New(secret []byte, lease time.Duration) Engine {
 	return NewImpl(secret, lease)
 }
@@ -0,0 +1,117 @@
+package mjwt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+const JTIKey = "jti"
+
+var ErrTokenRevoked = errors.New("token revoked")
+var ErrRevocationDisabled = errors.New("revocation store not configured")
+var ErrMissingJTI = errors.New("token has no jti")
+
+// RevocationStore is consulted when validating tokens, to deny tokens before they expire.
+type RevocationStore interface {
+	// Revoke denies the token with jti, the entry is no longer needed after expiresAt.
+	Revoke(jti string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+	// RevokeUser denies every token of the user issued before `before`,
+	// the entry is no longer needed after expiresAt.
+	RevokeUser(userID uint, before time.Time, expiresAt time.Time) error
+	// UserRevokedBefore returns the time before which tokens of the user are denied, zero if there is none.
+	UserRevokedBefore(userID uint) (time.Time, error)
+}
+
+type userRevocation struct {
+	before    time.Time
+	expiresAt time.Time
+}
+
+// MemoryRevocationStore is an in-memory RevocationStore, entries are pruned once they pass their expiry.
+type MemoryRevocationStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	users  map[uint]userRevocation
+
+	NowFunc func() time.Time
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens:  map[string]time.Time{},
+		users:   map[uint]userRevocation{},
+		NowFunc: time.Now,
+	}
+}
+
+func (s *MemoryRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryRevocationStore) IsRevoked(jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt, ok := s.tokens[jti]
+	return ok && expiresAt.After(s.NowFunc()), nil
+}
+
+func (s *MemoryRevocationStore) RevokeUser(userID uint, before time.Time, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	if previous, ok := s.users[userID]; ok && previous.before.After(before) {
+		return nil
+	}
+	s.users[userID] = userRevocation{
+		before:    before,
+		expiresAt: expiresAt,
+	}
+	return nil
+}
+
+func (s *MemoryRevocationStore) UserRevokedBefore(userID uint) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revocation, ok := s.users[userID]
+	if !ok || !revocation.expiresAt.After(s.NowFunc()) {
+		return time.Time{}, nil
+	}
+	return revocation.before, nil
+}
+
+// Len returns the number of entries kept, including expired ones not pruned yet.
+func (s *MemoryRevocationStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tokens) + len(s.users)
+}
+
+func (s *MemoryRevocationStore) prune() {
+	now := s.NowFunc()
+	for jti, expiresAt := range s.tokens {
+		if !expiresAt.After(now) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userID, revocation := range s.users {
+		if !revocation.expiresAt.After(now) {
+			delete(s.users, userID)
+		}
+	}
+}
+
+func newJTI() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
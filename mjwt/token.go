@@ -0,0 +1,59 @@
+package mjwt
+
+import "strings"
+
+// SigningMethod is the alg of a token, such as HS256, RS256, ES256 or EdDSA.
+type SigningMethod string
+
+const (
+	SigningMethodHS256 SigningMethod = "HS256"
+	SigningMethodHS384 SigningMethod = "HS384"
+	SigningMethodHS512 SigningMethod = "HS512"
+	SigningMethodRS256 SigningMethod = "RS256"
+	SigningMethodRS384 SigningMethod = "RS384"
+	SigningMethodRS512 SigningMethod = "RS512"
+	SigningMethodPS256 SigningMethod = "PS256"
+	SigningMethodPS384 SigningMethod = "PS384"
+	SigningMethodPS512 SigningMethod = "PS512"
+	SigningMethodES256 SigningMethod = "ES256"
+	SigningMethodES384 SigningMethod = "ES384"
+	SigningMethodES512 SigningMethod = "ES512"
+	SigningMethodEdDSA SigningMethod = "EdDSA"
+)
+
+func (m SigningMethod) Alg() string {
+	return string(m)
+}
+
+func (m SigningMethod) IsHMAC() bool {
+	return strings.HasPrefix(string(m), "HS")
+}
+
+// Claims are the decoded claims of a token.
+type Claims map[string]any
+
+// Token is a JSON web token, it does not depend on the JWT library used underneath.
+type Token struct {
+	// Raw is the encoded token, it is set by Parse.
+	Raw string
+
+	Method SigningMethod
+
+	Header map[string]any
+
+	Claims Claims
+
+	// Valid tells whether the signature has been verified, it is set by Parse.
+	Valid bool
+}
+
+func NewToken(method SigningMethod, claims Claims) *Token {
+	return &Token{
+		Method: method,
+		Header: map[string]any{
+			"typ": "JWT",
+			"alg": method.Alg(),
+		},
+		Claims: claims,
+	}
+}
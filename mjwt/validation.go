@@ -0,0 +1,120 @@
+package mjwt
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+var ErrTokenExpired = errors.New("token expired")
+var ErrTokenNotValidYet = errors.New("token not valid yet")
+var ErrTokenUsedBeforeIssued = errors.New("token used before issued")
+var ErrInvalidIssuer = errors.New("invalid issuer")
+var ErrInvalidAudience = errors.New("invalid audience")
+var ErrMissingClaim = errors.New("missing required claim")
+var ErrInvalidClaimFormat = errors.New("invalid claim format")
+
+// ClaimError tells which claim failed validation, Err is one of the errors above.
+type ClaimError struct {
+	Claim string
+	Err   error
+}
+
+func (e *ClaimError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Claim, e.Err)
+}
+
+func (e *ClaimError) Unwrap() error {
+	return e.Err
+}
+
+// validateClaims checks the registered claims of the token against the engine options.
+func (e *EngineImpl) validateClaims(claims Claims) error {
+	for _, claim := range e.RequiredClaims {
+		if _, ok := claims[claim]; !ok {
+			return &ClaimError{Claim: claim, Err: ErrMissingClaim}
+		}
+	}
+
+	now := e.NowFunc()
+
+	if _, ok := claims["exp"]; ok {
+		exp, ok := claimTime(claims, "exp")
+		if !ok {
+			return &ClaimError{Claim: "exp", Err: ErrInvalidClaimFormat}
+		}
+		if !now.Before(exp.Add(e.Leeway)) {
+			return &ClaimError{Claim: "exp", Err: ErrTokenExpired}
+		}
+	}
+
+	if _, ok := claims["nbf"]; ok {
+		nbf, ok := claimTime(claims, "nbf")
+		if !ok {
+			return &ClaimError{Claim: "nbf", Err: ErrInvalidClaimFormat}
+		}
+		if now.Add(e.Leeway).Before(nbf) {
+			return &ClaimError{Claim: "nbf", Err: ErrTokenNotValidYet}
+		}
+	}
+
+	if _, ok := claims["iat"]; ok {
+		iat, ok := claimTime(claims, "iat")
+		if !ok {
+			return &ClaimError{Claim: "iat", Err: ErrInvalidClaimFormat}
+		}
+		if now.Add(e.Leeway).Before(iat) {
+			return &ClaimError{Claim: "iat", Err: ErrTokenUsedBeforeIssued}
+		}
+	}
+
+	if e.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != e.Issuer {
+			return &ClaimError{Claim: "iss", Err: ErrInvalidIssuer}
+		}
+	}
+
+	if len(e.AcceptedAudiences) > 0 {
+		aud, ok := claimAudience(claims)
+		if !ok {
+			return &ClaimError{Claim: "aud", Err: ErrInvalidClaimFormat}
+		}
+		accepted := false
+		for _, a := range e.AcceptedAudiences {
+			if aud.Contains(a) {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return &ClaimError{Claim: "aud", Err: ErrInvalidAudience}
+		}
+	}
+
+	return nil
+}
+
+// claimAudience reads the aud claim, which is a string or an array of strings, a missing claim is empty.
+func claimAudience(claims Claims) (Audience, bool) {
+	switch v := claims["aud"].(type) {
+	case nil:
+		return nil, true
+	case string:
+		return Audience{v}, true
+	case Audience:
+		return v, true
+	case []string:
+		return v, true
+	case []any:
+		aud := make(Audience, 0, len(v))
+		for _, a := range v {
+			s, ok := a.(string)
+			if !ok {
+				return nil, false
+			}
+			aud = append(aud, s)
+		}
+		return aud, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,183 @@
+package mtotp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPeriod = 30 * time.Second
+	DefaultDigits = 6
+
+	// SecretSize is the size of the secrets generated by NewKey, as recommended by RFC 4226.
+	SecretSize = 20
+)
+
+var ErrSecretMalformed = errors.New("malformed secret")
+var ErrInvalidPeriod = errors.New("period must be a whole number of seconds")
+var ErrInvalidDigits = errors.New("digits must be between 6 and 8")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Key is a TOTP key as described in RFC 6238, with HMAC-SHA1, the algorithm authenticator apps support.
+type Key struct {
+	Secret []byte
+
+	// Period defaults to DefaultPeriod, it must be a whole number of seconds.
+	Period time.Duration
+
+	// Digits defaults to DefaultDigits, it must be between 6 and 8 as RFC 4226 allows.
+	Digits int
+}
+
+// NewKeyWithOptions creates a key with a non-default period or number of digits, zero values take the defaults.
+func NewKeyWithOptions(secret []byte, period time.Duration, digits int) (*Key, error) {
+	key := &Key{Secret: secret, Period: period, Digits: digits}
+	if err := key.Check(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewKey generates a key with a random secret.
+func NewKey() (*Key, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "generate secret error")
+	}
+	return &Key{Secret: secret}, nil
+}
+
+// ParseKey creates a key from its base32 secret, as returned by Key.String, spaces and padding are ignored.
+func ParseKey(secret string) (*Key, error) {
+	secret = strings.TrimRight(strings.ToUpper(strings.ReplaceAll(secret, " ", "")), "=")
+	decoded, err := encoding.DecodeString(secret)
+	if err != nil || len(decoded) == 0 {
+		return nil, ErrSecretMalformed
+	}
+	return &Key{Secret: decoded}, nil
+}
+
+// String returns the base32 secret of the key.
+func (k *Key) String() string {
+	return encoding.EncodeToString(k.Secret)
+}
+
+func (k *Key) period() time.Duration {
+	if k.Period <= 0 {
+		return DefaultPeriod
+	}
+	return k.Period
+}
+
+func (k *Key) digits() int {
+	if k.Digits <= 0 {
+		return DefaultDigits
+	}
+	return k.Digits
+}
+
+// Check returns an error if the key has no secret, or a period or a number of digits codes can not be computed with.
+func (k *Key) Check() error {
+	if len(k.Secret) == 0 {
+		return ErrSecretMalformed
+	}
+	if k.Period != 0 && (k.Period < time.Second || k.Period%time.Second != 0) {
+		return ErrInvalidPeriod
+	}
+	return checkDigits(k.digits())
+}
+
+func checkDigits(digits int) error {
+	if digits < 6 || digits > 8 {
+		return ErrInvalidDigits
+	}
+	return nil
+}
+
+// Counter returns the time step of t.
+func (k *Key) Counter(t time.Time) (uint64, error) {
+	if err := k.Check(); err != nil {
+		return 0, err
+	}
+	return uint64(t.Unix() / int64(k.period()/time.Second)), nil
+}
+
+// Code returns the code of the key at t.
+func (k *Key) Code(t time.Time) (string, error) {
+	counter, err := k.Counter(t)
+	if err != nil {
+		return "", err
+	}
+	return HOTP(k.Secret, counter, k.digits())
+}
+
+// Validate reports whether code is the code at t, or at most skew periods before or after it,
+// counter is the time step it matches, so that callers can refuse codes of steps they have accepted before.
+func (k *Key) Validate(code string, t time.Time, skew int) (counter uint64, ok bool, err error) {
+	current, err := k.Counter(t)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := -skew; i <= skew; i++ {
+		if i < 0 && uint64(-i) > current {
+			continue
+		}
+		c := current + uint64(i)
+		expected, err := HOTP(k.Secret, c, k.digits())
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return c, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// URI returns the otpauth:// URI of the key, authenticator apps enroll it from a QR code of the URI.
+func (k *Key) URI(issuer string, account string) string {
+	label := url.PathEscape(account)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+	query := url.Values{}
+	query.Set("secret", k.String())
+	if issuer != "" {
+		query.Set("issuer", issuer)
+	}
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(k.digits()))
+	query.Set("period", strconv.Itoa(int(k.period()/time.Second)))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// HOTP returns the code of the counter as described in RFC 4226, digits must be between 6 and 8.
+func HOTP(secret []byte, counter uint64, digits int) (string, error) {
+	if err := checkDigits(digits); err != nil {
+		return "", err
+	}
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod), nil
+}
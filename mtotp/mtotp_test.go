@@ -0,0 +1,125 @@
+package mtotp
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	assertions := require.New(t)
+
+	// test values of RFC 4226 appendix D
+	secret := []byte("12345678901234567890")
+	for counter, code := range []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"} {
+		hotp, err := HOTP(secret, uint64(counter), 6)
+		assertions.Nil(err)
+		assertions.Equal(code, hotp)
+	}
+	_, err := HOTP(secret, 0, 10)
+	assertions.ErrorIs(err, ErrInvalidDigits)
+}
+
+func TestKey_Code(t *testing.T) {
+	assertions := require.New(t)
+
+	// test values of RFC 6238 appendix B, for SHA1
+	key := &Key{Secret: []byte("12345678901234567890"), Digits: 8}
+	for unix, code := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		totp, err := key.Code(time.Unix(unix, 0))
+		assertions.Nil(err)
+		assertions.Equal(code, totp)
+	}
+
+	// keys codes can not be computed with are rejected instead of panicking
+	for _, key := range []*Key{
+		{Secret: key.Secret, Period: time.Millisecond},
+		{Secret: key.Secret, Period: 1500 * time.Millisecond},
+		{Secret: key.Secret, Digits: 10},
+		{Secret: key.Secret, Digits: 4},
+		{},
+	} {
+		_, err := key.Code(time.Unix(59, 0))
+		assertions.NotNil(err)
+		_, _, err = key.Validate("123456", time.Unix(59, 0), 1)
+		assertions.NotNil(err)
+	}
+	_, err := NewKeyWithOptions(key.Secret, time.Millisecond, 0)
+	assertions.ErrorIs(err, ErrInvalidPeriod)
+	_, err = NewKeyWithOptions(key.Secret, 0, 9)
+	assertions.ErrorIs(err, ErrInvalidDigits)
+	key, err = NewKeyWithOptions(key.Secret, 60*time.Second, 8)
+	assertions.Nil(err)
+	assertions.Equal(60*time.Second, key.Period)
+}
+
+func TestKey_Validate(t *testing.T) {
+	assertions := require.New(t)
+
+	key, err := NewKey()
+	assertions.Nil(err)
+	assertions.Len(key.Secret, SecretSize)
+	now := time.Unix(1700000000, 0)
+	code := func(t time.Time) string {
+		code, err := key.Code(t)
+		assertions.Nil(err)
+		return code
+	}
+	current, err := key.Counter(now)
+	assertions.Nil(err)
+
+	counter, ok, err := key.Validate(code(now), now, 1)
+	assertions.Nil(err)
+	assertions.True(ok)
+	assertions.Equal(current, counter)
+
+	// the previous and the next codes are accepted within the skew
+	counter, ok, _ = key.Validate(code(now.Add(-DefaultPeriod)), now, 1)
+	assertions.True(ok)
+	assertions.Equal(current-1, counter)
+	_, ok, _ = key.Validate(code(now.Add(DefaultPeriod)), now, 1)
+	assertions.True(ok)
+	_, ok, _ = key.Validate(code(now.Add(-DefaultPeriod)), now, 0)
+	assertions.False(ok)
+	_, ok, _ = key.Validate(code(now.Add(-2*DefaultPeriod)), now, 1)
+	assertions.False(ok)
+	_, ok, _ = key.Validate("", now, 1)
+	assertions.False(ok)
+}
+
+func TestParseKey(t *testing.T) {
+	assertions := require.New(t)
+
+	key := &Key{Secret: []byte("12345678901234567890")}
+	assertions.Equal("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", key.String())
+
+	parsed, err := ParseKey("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	assertions.Nil(err)
+	assertions.Equal(key.Secret, parsed.Secret)
+
+	_, err = ParseKey("not base32!")
+	assertions.ErrorIs(err, ErrSecretMalformed)
+	_, err = ParseKey("")
+	assertions.ErrorIs(err, ErrSecretMalformed)
+
+	uri, err := url.Parse(key.URI("Example Co", "alice@example.com"))
+	assertions.Nil(err)
+	assertions.Equal("otpauth", uri.Scheme)
+	assertions.Equal("totp", uri.Host)
+	assertions.Equal("/Example Co:alice@example.com", uri.Path)
+	assertions.Equal(url.Values{
+		"secret":    {"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+		"issuer":    {"Example Co"},
+		"algorithm": {"SHA1"},
+		"digits":    {"6"},
+		"period":    {"30"},
+	}, uri.Query())
+}